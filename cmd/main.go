@@ -2,11 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"ez-snapshot/internal/config"
 	"ez-snapshot/internal/deps"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/backup"
 	"ez-snapshot/internal/usecase"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/c-bata/go-prompt"
 	log "github.com/sirupsen/logrus"
@@ -15,14 +27,37 @@ import (
 type Command struct {
 	Name        string
 	Description string
+	Flags       func(fs *flag.FlagSet) // optional, registers the command flags
 	Run         func(ctx context.Context) error
 }
 
 func main() {
 	ctx := context.Background()
-	depUc := usecase.NewDependencyChecker(deps.NewStorageRepo(ctx))
+
+	// os.Exit skips deferred calls, the spawned rclone daemon must be stopped
+	// explicitly
+	daemon := deps.NewRCloneDaemon(ctx)
+	defer daemon.Stop()
+	exit := func(code int) {
+		daemon.Stop()
+		os.Exit(code)
+	}
+
+	depUc := usecase.NewDependencyChecker(deps.NewStorageRepo(ctx), deps.NewStorageTypes(ctx))
 	if err := depUc.Check(); err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		exit(1)
+	}
+
+	var listDetails, differential bool
+	var backupTags map[string]string
+	var restoreTo string
+	var transferFrom, transferTo, transferMatch string
+	var syncPrune bool
+	transferFlags := func(fs *flag.FlagSet) {
+		fs.StringVar(&transferFrom, "from", "", "storage target or remote to copy from, the configured storage when empty")
+		fs.StringVar(&transferTo, "to", "", "storage target or remote to copy to")
+		fs.StringVar(&transferMatch, "match", "", "only the backups whose name matches this pattern, eg : db_202610*")
 	}
 
 	// define available commands
@@ -30,21 +65,51 @@ func main() {
 		{
 			Name:        "backup",
 			Description: "Create a new database backup",
+			Flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&differential, "differential", false, "only back up the tables changed since the latest full backup")
+				backupTags = map[string]string{}
+				fs.Func("tag", "attach a key=value tag to the backup, may be repeated", func(s string) error {
+					k, v, ok := strings.Cut(s, "=")
+					if !ok {
+						return fmt.Errorf("invalid tag %q, expected key=value", s)
+					}
+					backupTags[k] = v
+					return nil
+				})
+			},
 			Run: func(ctx context.Context) error {
 				fmt.Println("Running database backup...")
 				uc := usecase.NewBackupDatabaseUseCase(
 					deps.NewBackupRepo(ctx),
 					deps.NewStorageRepo(ctx),
+					deps.NewSignaturePolicy(ctx),
+					backupTags,
 				)
+				if differential {
+					return uc.ExecuteDifferential(ctx)
+				}
 				return uc.Execute(ctx)
 			},
 		},
 		{
 			Name:        "restore",
 			Description: "Restore database from a selected backup",
+			Flags: func(fs *flag.FlagSet) {
+				fs.Func("identity", "age identity file used to decrypt the backup", setConfig("encryption.identity_file"))
+				fs.StringVar(&restoreTo, "to", "", "point in time to recover to, a local time or a GTID set")
+			},
 			Run: func(ctx context.Context) error {
+				if restoreTo != "" {
+					target, err := backup.ParseRecoveryTarget(restoreTo)
+					if err != nil {
+						return err
+					}
+					uc := usecase.NewRestoreDatabaseUseCase(deps.NewBackupRepo(ctx), deps.NewStorageRepo(ctx), deps.NewSignaturePolicy(ctx))
+					return uc.ExecuteToPoint(ctx, target)
+				}
+
 				fmt.Println("Listing backups...")
-				listDbUc := usecase.NewListDatabaseUseCase(deps.NewStorageRepo(ctx))
+				listDbUc := usecase.NewListDatabaseUseCase(deps.NewStorageRepo(ctx), deps.NewSignaturePolicy(ctx))
 				list, err := listDbUc.Execute(ctx)
 				if err != nil {
 					return err
@@ -56,7 +121,7 @@ func main() {
 				}
 
 				for i, d := range list {
-					fmt.Printf("[%d]: %s\n", i, d.Name)
+					fmt.Printf("[%d]: %s\n", i, backupLabel(d))
 				}
 
 				completer := func(d prompt.Document) []prompt.Suggest {
@@ -80,16 +145,19 @@ func main() {
 
 				backupKey := list[index].Path
 
-				uc := usecase.NewRestoreDatabaseUseCase(deps.NewBackupRepo(ctx), deps.NewStorageRepo(ctx))
+				uc := usecase.NewRestoreDatabaseUseCase(deps.NewBackupRepo(ctx), deps.NewStorageRepo(ctx), deps.NewSignaturePolicy(ctx))
 				return uc.Execute(ctx, backupKey)
 			},
 		},
 		{
 			Name:        "list",
 			Description: "List available backups",
+			Flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&listDetails, "details", false, "read the manifest of every backup")
+			},
 			Run: func(ctx context.Context) error {
 				fmt.Println("Listing backups...")
-				uc := usecase.NewListDatabaseUseCase(deps.NewStorageRepo(ctx))
+				uc := usecase.NewListDatabaseUseCase(deps.NewStorageRepo(ctx), deps.NewSignaturePolicy(ctx))
 				list, err := uc.Execute(ctx)
 				if err != nil {
 					return err
@@ -100,10 +168,129 @@ func main() {
 					return nil
 				}
 
+				if !listDetails {
+					for i, d := range list {
+						fmt.Printf("[%d]: %s\n", i, backupLabel(d))
+					}
+					return nil
+				}
+
+				inspectUc := usecase.NewInspectBackupUseCase(deps.NewBackupRepo(ctx), deps.NewStorageRepo(ctx))
 				for i, d := range list {
-					fmt.Printf("[%d]: %s\n", i, d.Name)
+					manifest, err := inspectUc.Execute(ctx, d.Path)
+					switch {
+					case errors.Is(err, backup.ErrNoManifest):
+						fmt.Printf("[%d]: %s (no manifest)\n", i, backupLabel(d))
+					case err != nil:
+						fmt.Printf("[%d]: %s (%v)\n", i, backupLabel(d), err)
+					case manifest.IsDifferential():
+						fmt.Printf("[%d]: %s (differential of %s, %d of %d tables)\n", i, backupLabel(d),
+							manifest.Base, len(manifest.DumpedTables), len(manifest.Tables))
+					default:
+						fmt.Printf("[%d]: %s (MySQL %s, %d tables)\n", i, backupLabel(d),
+							manifest.ServerVersion, len(manifest.Tables))
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "binlog",
+			Description: "Continuously archive binary logs for point-in-time recovery",
+			Run: func(ctx context.Context) error {
+				ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+
+				cfg := deps.NewBinlogConfig(ctx)
+				uc := usecase.NewArchiveBinlogUseCase(
+					deps.NewBackupRepo(ctx),
+					deps.NewStorageRepo(ctx),
+					deps.NewSignaturePolicy(ctx),
+					cfg.SpoolDir,
+					cfg.UploadInterval,
+				)
+				return uc.Execute(ctx)
+			},
+		},
+		{
+			Name:        "gc",
+			Description: "Remove chunks no backup refers to anymore",
+			Run: func(ctx context.Context) error {
+				fmt.Println("Collecting unreferenced chunks...")
+				uc := usecase.NewGarbageCollectUseCase(deps.NewStorageRepo(ctx))
+				removed, err := uc.Execute(ctx)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("✅ %d chunk(s) removed\n", removed)
+				return nil
+			},
+		},
+		{
+			Name:        "copy",
+			Description: "Copy selected backups to another storage target",
+			Flags:       transferFlags,
+			Run: func(ctx context.Context) error {
+				if transferTo == "" {
+					return fmt.Errorf("copy: --to is required")
+				}
+
+				uc := usecase.NewCopyBackupsUseCase(deps.NewStorageTarget(ctx, transferFrom), deps.NewStorageTarget(ctx, transferTo))
+				list, err := uc.Select(ctx, transferMatch)
+				if err != nil {
+					return err
+				}
+
+				if len(list) == 0 {
+					fmt.Println("No backup(s) found")
+					return nil
+				}
+
+				// without a pattern the backups to copy are picked by hand
+				if transferMatch == "" {
+					for i, d := range list {
+						fmt.Printf("[%d]: %s\n", i, backupLabel(d))
+					}
+
+					input := prompt.Input("Select backup numbers (eg : 0,2 or all) >", func(d prompt.Document) []prompt.Suggest {
+						return prompt.FilterHasPrefix([]prompt.Suggest{{Text: "all"}}, d.GetWordBeforeCursor(), true)
+					})
+					if list, err = selectBackups(list, input); err != nil {
+						return err
+					}
+				}
+
+				copied, err := uc.Execute(ctx, list)
+				if err != nil {
+					return err
 				}
 
+				fmt.Printf("✅ %d backup(s) copied to %s\n", copied, transferTo)
+				return nil
+			},
+		},
+		{
+			Name:        "sync",
+			Description: "Copy every backup missing from another storage target",
+			Flags: func(fs *flag.FlagSet) {
+				transferFlags(fs)
+				fs.BoolVar(&syncPrune, "prune", false, "remove the backups the source doesn't hold anymore from the destination")
+			},
+			Run: func(ctx context.Context) error {
+				if transferTo == "" {
+					return fmt.Errorf("sync: --to is required")
+				}
+
+				fmt.Println("Syncing backups...")
+				uc := usecase.NewSyncBackupsUseCase(deps.NewStorageTarget(ctx, transferFrom), deps.NewStorageTarget(ctx, transferTo), syncPrune)
+				copied, removed, err := uc.Execute(ctx, transferMatch)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("✅ %d backup(s) copied to %s, %d removed\n", copied, transferTo, removed)
 				return nil
 			},
 		},
@@ -138,18 +325,23 @@ func main() {
 		}
 
 		if cmd, ok := commandMap[arg]; ok {
+			if err := parseFlags(cmd, os.Args[2:]); err != nil {
+				fmt.Println(err)
+				printHelp()
+				exit(1)
+			}
 			if err := cmd.Run(ctx); err != nil {
 				if err.Error() == "exit" {
-					os.Exit(0)
+					exit(0)
 				}
 				log.Error(err)
-				os.Exit(1)
+				exit(1)
 			}
 			return
 		} else {
 			fmt.Println("Unknown command:", os.Args[1])
 			printHelp()
-			os.Exit(1)
+			exit(1)
 		}
 	}
 
@@ -166,10 +358,19 @@ func main() {
 	printHelp()
 	for {
 		input := prompt.Input("> ", completer)
+		name, args, _ := strings.Cut(strings.TrimSpace(input), " ")
 
-		if cmd, ok := commandMap[input]; ok {
-			err := cmd.Run(ctx)
+		if cmd, ok := commandMap[name]; ok {
+			fields, err := splitArgs(args)
 			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if err := parseFlags(cmd, fields); err != nil {
+				log.Error(err)
+				continue
+			}
+			if err := cmd.Run(ctx); err != nil {
 				if err.Error() == "exit" {
 					break
 				}
@@ -182,14 +383,168 @@ func main() {
 	}
 }
 
+// splitArgs splits the arguments typed in interactive mode like a shell
+// would, quotes keep spaces, eg : --to "2026-10-18 14:03:00".
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// parseFlags applies the command flags on top of the config file.
+func parseFlags(cmd Command, args []string) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s: %w", cmd.Name, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%s: unexpected argument %q", cmd.Name, fs.Arg(0))
+	}
+	return nil
+}
+
+// backupLabel returns the backup name with what is known about it, its
+// signature status and the targets holding it, if any.
+func backupLabel(b *entity.Backup) string {
+	var details []string
+	if b.Database != "" {
+		details = append(details, strings.TrimSpace(b.Engine+" "+b.Database))
+	}
+	if !b.CreatedAt.IsZero() {
+		details = append(details, b.CreatedAt.Local().Format(time.DateTime))
+	}
+	details = append(details, formatSize(b.Size))
+	if b.Checksum != "" {
+		details = append(details, "sha256 "+b.Checksum[:min(12, len(b.Checksum))])
+	}
+
+	tags := make([]string, 0, len(b.Tags))
+	for k, v := range b.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	details = append(details, tags...)
+
+	label := fmt.Sprintf("%s (%s)", b.Name, strings.Join(details, ", "))
+	if b.Signature != "" {
+		label += fmt.Sprintf(" [%s]", b.Signature)
+	}
+	if len(b.Replicas) > 0 {
+		label += fmt.Sprintf(" (on %s)", strings.Join(b.Replicas, ", "))
+	}
+	return label
+}
+
+// formatSize returns n bytes in a human readable unit, eg : 1.5 MiB.
+func formatSize(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, unit := float64(n)/(1<<10), 0
+	for size >= 1<<10 && unit < 4 {
+		size /= 1 << 10
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGTP"[unit])
+}
+
+// selectBackups returns the backups picked by a comma separated list of
+// numbers, or all of them.
+func selectBackups(list []*entity.Backup, input string) ([]*entity.Backup, error) {
+	input = strings.TrimSpace(input)
+	if input == "all" {
+		return list, nil
+	}
+
+	var selected []*entity.Backup
+	for _, s := range strings.Split(input, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("invalid backup number")
+		}
+		selected = append(selected, list[index])
+	}
+	return selected, nil
+}
+
+// setConfig returns a flag handler overriding the config key with the flag value.
+func setConfig(key string) func(string) error {
+	return func(v string) error {
+		config.Set(key, v)
+		return nil
+	}
+}
+
 func printHelp() {
 	fmt.Println("\nUsage:")
-	fmt.Println("  ez-snapshot --<command>\n")
+	fmt.Println("  ez-snapshot --<command>")
+	fmt.Println()
 	fmt.Println("Available commands:")
 	fmt.Println("  --backup     Create a new database backup")
 	fmt.Println("  --restore    Restore database from a selected backup")
 	fmt.Println("  --list       List available backups")
+	fmt.Println("  --binlog     Continuously archive binary logs for point-in-time recovery")
+	fmt.Println("  --gc         Remove chunks no backup refers to anymore (chunked layout)")
+	fmt.Println("  --copy       Copy selected backups to another storage target")
+	fmt.Println("  --sync       Copy every backup missing from another storage target")
 	fmt.Println("  --help       Show this help message")
 	fmt.Println("  --exit       Exit the CLI (interactive mode only)")
 	fmt.Println()
+	fmt.Println("Flags:")
+	fmt.Println("  --backup --differential        only back up the tables changed since the latest full backup")
+	fmt.Println("  --backup --tag <key=value>     attach a tag to the backup, may be repeated")
+	fmt.Println("  --list --details               read the manifest of every backup")
+	fmt.Println("  --restore --identity <file>    age identity file used to decrypt the backup")
+	fmt.Println("  --restore --to <time|gtid>     recover to a point in time, eg : \"2026-10-18 14:03:00\" or 2026-10-18T14:03:00+07:00")
+	fmt.Println("  --copy --to <target>           storage target or remote to copy to (required)")
+	fmt.Println("  --copy --from <target>         storage target or remote to copy from")
+	fmt.Println("  --copy --match <pattern>       copy every backup matching the pattern, eg : \"db_??????01_*\"")
+	fmt.Println("  --sync --to <target>           same flags as copy, every backup by default")
+	fmt.Println("  --sync --prune                 also remove the backups the source doesn't hold anymore")
+	fmt.Println()
 }
@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec is the compression applied on top of the tar stream.
+type Codec string
+
+const (
+	None Codec = "none"
+	Gzip Codec = "gzip"
+	Zstd Codec = "zstd"
+	Xz   Codec = "xz"
+	Lz4  Codec = "lz4"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// lz4Levels maps the levels 1 to 9 to the lz4 ones, which aren't numbered.
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// ParseCodec converts a config value into a Codec, an empty value means gzip.
+func ParseCodec(s string) (Codec, error) {
+	switch c := Codec(s); c {
+	case "":
+		return Gzip, nil
+	case None, Gzip, Zstd, Xz, Lz4:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unsupported compression: %s", s)
+	}
+}
+
+// Ext returns the file extension of a tar archive compressed with the codec.
+func (c Codec) Ext() string {
+	switch c {
+	case Gzip:
+		return ".tar.gz"
+	case Zstd:
+		return ".tar.zst"
+	case Xz:
+		return ".tar.xz"
+	case Lz4:
+		return ".tar.lz4"
+	default:
+		return ".tar"
+	}
+}
+
+// Compress wraps w with the codec, level 0 picks the codec default.
+// Closing the returned writer flushes the codec but leaves w open.
+func Compress(w io.Writer, c Codec, level int) (io.WriteCloser, error) {
+	switch c {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		opts := []zstd.EOption{}
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("invalid zstd compression level: %d, use 1 to 22", level)
+			}
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case Xz:
+		// xz has no numeric level, the default preset is used
+		if level != 0 {
+			return nil, fmt.Errorf("xz compression doesn't support levels, got level %d", level)
+		}
+		return xz.NewWriter(w)
+	case Lz4:
+		zw := lz4.NewWriter(w)
+		if level != 0 {
+			if level < 1 || level > len(lz4Levels) {
+				return nil, fmt.Errorf("invalid lz4 compression level: %d, use 1 to %d", level, len(lz4Levels))
+			}
+			if err := zw.Apply(lz4.CompressionLevelOption(lz4Levels[level-1])); err != nil {
+				return nil, err
+			}
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", c)
+	}
+}
+
+// DetectCodec guesses the codec from the first bytes of a stream.
+func DetectCodec(peek []byte) Codec {
+	switch {
+	case bytes.HasPrefix(peek, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(peek, zstdMagic):
+		return Zstd
+	case bytes.HasPrefix(peek, xzMagic):
+		return Xz
+	case bytes.HasPrefix(peek, lz4Magic):
+		return Lz4
+	default:
+		return None
+	}
+}
+
+// Decompress detects the codec from the magic bytes of r and returns a reader
+// of the decompressed stream.
+func Decompress(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read input: %w", err)
+	}
+
+	c := DetectCodec(peek)
+	switch c {
+	case Gzip:
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, c, fmt.Errorf("failed to open gzip: %w", err)
+		}
+		return gzr, c, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, c, fmt.Errorf("failed to open zstd: %w", err)
+		}
+		return zr.IOReadCloser(), c, nil
+	case Xz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, c, fmt.Errorf("failed to open xz: %w", err)
+		}
+		return io.NopCloser(xr), c, nil
+	case Lz4:
+		return io.NopCloser(lz4.NewReader(br)), c, nil
+	default:
+		return io.NopCloser(br), c, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,62 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompressLevels(t *testing.T) {
+	tests := []struct {
+		codec   Codec
+		level   int
+		wantErr bool
+	}{
+		{Gzip, 0, false},
+		{Gzip, 9, false},
+		{Gzip, 10, true},
+		{Zstd, 0, false},
+		{Zstd, 19, false},
+		{Zstd, 23, true},
+		{Xz, 0, false},
+		{Xz, 6, true},
+		{Lz4, 0, false},
+		{Lz4, 1, false},
+		{Lz4, 9, false},
+		{Lz4, 10, true},
+		{Lz4, -1, true},
+		{None, 0, false},
+	}
+
+	plain := bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'ez-snapshot');\n"), 1000)
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w, err := Compress(&buf, tt.codec, tt.level)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s level %d: Compress succeeded, want an error", tt.codec, tt.level)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s level %d: %v", tt.codec, tt.level, err)
+			continue
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, codec, err := Decompress(&buf)
+		if err != nil {
+			t.Fatalf("%s level %d: %v", tt.codec, tt.level, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || codec != tt.codec || !bytes.Equal(got, plain) {
+			t.Errorf("%s level %d: decompressed %d bytes as %s (%v), want %d bytes as %s", tt.codec, tt.level, len(got), codec, err, len(plain), tt.codec)
+		}
+	}
+}
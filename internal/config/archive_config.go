@@ -0,0 +1,21 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type ArchiveConfig struct {
+	Compression string // none, gzip, zstd, xz or lz4
+	Level       int    // compression level, 0 means codec default
+}
+
+func LoadArchiveConfig() (*ArchiveConfig, error) {
+	viper.SetDefault("archive.compression", "gzip")
+
+	cfg := &ArchiveConfig{
+		Compression: viper.GetString("archive.compression"),
+		Level:       viper.GetInt("archive.level"),
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+type AzureConfig struct {
+	Endpoint  string // eg : http://127.0.0.1:10000/devstoreaccount1 for Azurite
+	Account   string
+	Container string
+	Prefix    string // blob name prefix, eg : db-backup/
+
+	// AccountKey signs requests with Shared Key, SASToken is appended to them
+	// instead when set.
+	AccountKey string
+	SASToken   string
+
+	AccessTier string // eg : Cool, Cold, Archive
+	BlockSize  int64  // staged block size
+}
+
+func loadAzureConfig(v *viper.Viper) (*AzureConfig, error) {
+	v.SetDefault("azure.block_size", "16MB")
+
+	cfg := &AzureConfig{
+		Endpoint:  v.GetString("azure.endpoint"),
+		Account:   v.GetString("azure.account"),
+		Container: v.GetString("azure.container"),
+		Prefix:    v.GetString("azure.prefix"),
+
+		AccountKey: v.GetString("azure.account_key"),
+		SASToken:   v.GetString("azure.sas_token"),
+
+		AccessTier: v.GetString("azure.access_tier"),
+		BlockSize:  int64(v.GetSizeInBytes("azure.block_size")),
+	}
+
+	// fall back to the environment variables of the az cli
+	if cfg.Account == "" {
+		cfg.Account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if cfg.AccountKey == "" && cfg.SASToken == "" {
+		cfg.AccountKey = os.Getenv("AZURE_STORAGE_KEY")
+		cfg.SASToken = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+
+	return cfg, nil
+}
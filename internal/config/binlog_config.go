@@ -0,0 +1,31 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type BinlogConfig struct {
+	// Enabled records the binary log coordinates of every full backup, they
+	// are needed to replay the archived binary logs on top of it.
+	Enabled bool
+
+	SpoolDir       string        // local directory binary logs are streamed into before upload
+	UploadInterval time.Duration // how often streamed binary logs are uploaded
+}
+
+func LoadBinlogConfig() (*BinlogConfig, error) {
+	viper.SetDefault("binlog.spool_dir", filepath.Join(os.TempDir(), "ez-snapshot-binlog"))
+	viper.SetDefault("binlog.upload_interval", time.Minute)
+
+	cfg := &BinlogConfig{
+		Enabled:        viper.GetBool("binlog.enabled"),
+		SpoolDir:       expandPath(viper.GetString("binlog.spool_dir")),
+		UploadInterval: viper.GetDuration("binlog.upload_interval"),
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+type EncryptionConfig struct {
+	Passphrase string // passphrase used to derive the archive key
+	KeyFile    string // file holding the archive key, eg : ~/.config/ez-snapshot/backup.key
+
+	Recipients   []string // age public keys archives are encrypted to, eg : age1ql3z...
+	IdentityFile string   // age identity file used to decrypt archives on restore
+}
+
+func LoadEncryptionConfig() (*EncryptionConfig, error) {
+	cfg := &EncryptionConfig{
+		Passphrase: viper.GetString("encryption.passphrase"),
+		KeyFile:    expandPath(viper.GetString("encryption.key_file")),
+
+		Recipients:   viper.GetStringSlice("encryption.recipients"),
+		IdentityFile: expandPath(viper.GetString("encryption.identity_file")),
+	}
+
+	if cfg.Passphrase != "" && cfg.KeyFile != "" {
+		return nil, fmt.Errorf("encryption.passphrase and encryption.key_file are mutually exclusive")
+	}
+
+	if len(cfg.Recipients) > 0 && (cfg.Passphrase != "" || cfg.KeyFile != "") {
+		return nil, fmt.Errorf("encryption.recipients can't be combined with encryption.passphrase or encryption.key_file")
+	}
+
+	return cfg, nil
+}
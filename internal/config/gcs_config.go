@@ -0,0 +1,40 @@
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+type GCSConfig struct {
+	Endpoint string // eg : http://localhost:4443 for fake-gcs-server
+	Bucket   string
+	Prefix   string // object name prefix, eg : db-backup/
+
+	// CredentialsFile is a service account key (JSON). Without it the token
+	// of the GCE metadata server is used, or no token with a custom endpoint.
+	CredentialsFile string
+
+	StorageClass string // eg : NEARLINE
+	ChunkSize    int64  // resumable upload chunk size
+}
+
+func loadGCSConfig(v *viper.Viper) (*GCSConfig, error) {
+	v.SetDefault("gcs.endpoint", "https://storage.googleapis.com")
+	v.SetDefault("gcs.chunk_size", "16MB")
+
+	cfg := &GCSConfig{
+		Endpoint:        v.GetString("gcs.endpoint"),
+		Bucket:          v.GetString("gcs.bucket"),
+		Prefix:          v.GetString("gcs.prefix"),
+		CredentialsFile: expandPath(v.GetString("gcs.credentials_file")),
+		StorageClass:    v.GetString("gcs.storage_class"),
+		ChunkSize:       int64(v.GetSizeInBytes("gcs.chunk_size")),
+	}
+
+	if cfg.CredentialsFile == "" {
+		cfg.CredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	return cfg, nil
+}
@@ -15,3 +15,8 @@ func init() {
 		panic(err)
 	}
 }
+
+// Set overrides a config value, eg : with a command line flag.
+func Set(key string, value any) {
+	viper.Set(key, value)
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type LocalConfig struct {
+	Path string // directory backups are stored in, eg : /mnt/nfs/db-backup
+}
+
+func loadLocalConfig(v *viper.Viper) (*LocalConfig, error) {
+	cfg := &LocalConfig{
+		Path: expandPath(v.GetString("local.path")),
+	}
+
+	return cfg, nil
+}
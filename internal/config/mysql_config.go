@@ -10,6 +10,10 @@ type MySQLConfig struct {
 	Username string
 	Password string
 	Database string
+
+	// ChecksumTables detects tables changed since the last full backup with
+	// CHECKSUM TABLE, slower but exact.
+	ChecksumTables bool
 }
 
 func LoadMySQLConfig() (*MySQLConfig, error) {
@@ -22,6 +26,8 @@ func LoadMySQLConfig() (*MySQLConfig, error) {
 		Username: viper.GetString("mysql.username"),
 		Password: viper.GetString("mysql.password"),
 		Database: viper.GetString("mysql.database"),
+
+		ChecksumTables: viper.GetBool("mysql.checksum_tables"),
 	}
 
 	return cfg, nil
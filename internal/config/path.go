@@ -0,0 +1,19 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandPath resolves a leading ~ to the home directory of the current user.
+func expandPath(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
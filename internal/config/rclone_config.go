@@ -1,22 +1,70 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type RCloneConfig struct {
-	Host   string // rclone host, eg : http://localhost:5572
+	Host   string // rclone host, eg : http://localhost:5572 or https://backup-host:5572
 	Fs     string // file system, eg : s3:my-aws-bucket
 	Remote string // remote path
+
+	// StagingDir is a directory on the rclone daemon host that is used as a
+	// local staging remote when the backend can't serve files directly.
+	// It must be readable by ez-snapshot, eg : /tmp/ez-snapshot
+	StagingDir string
+
+	// TransferMode is stream to send backups through the rc request itself,
+	// or async to stage them in StagingDir and transfer them with rclone jobs.
+	TransferMode string
+
+	// Username and Password match rclone rcd --rc-user / --rc-pass, Bearer is
+	// sent instead to an rc API behind an authenticating proxy.
+	Username string
+	Password string
+	Bearer   string
+
+	CACert     string // PEM bundle trusted in addition to the system roots
+	ClientCert string // PEM client certificate for mutual TLS
+	ClientKey  string
+
+	ConnectTimeout  time.Duration // dial and TLS handshake
+	ResponseTimeout time.Duration // wait for the response once the request is sent, 0 waits forever
+
+	// Spawn starts rclone rcd on a random localhost port when Host is not
+	// reachable, ConfigFile overrides the rclone config it reads remotes from.
+	Spawn      bool
+	ConfigFile string
 }
 
-func LoadRCloneConfig() (*RCloneConfig, error) {
-	viper.SetDefault("rclone.host", "http://localhost:5572")
+func loadRCloneConfig(v *viper.Viper) (*RCloneConfig, error) {
+	v.SetDefault("rclone.host", "http://localhost:5572")
+	v.SetDefault("rclone.connect_timeout", "10s")
+	v.SetDefault("rclone.transfer_mode", "stream")
 
 	cfg := &RCloneConfig{
-		Host:   viper.GetString("rclone.host"),
-		Fs:     viper.GetString("rclone.fs"),
-		Remote: viper.GetString("rclone.remote"),
+		Host:   v.GetString("rclone.host"),
+		Fs:     v.GetString("rclone.fs"),
+		Remote: v.GetString("rclone.remote"),
+
+		StagingDir:   v.GetString("rclone.staging_dir"),
+		TransferMode: v.GetString("rclone.transfer_mode"),
+
+		Username: v.GetString("rclone.username"),
+		Password: v.GetString("rclone.password"),
+		Bearer:   v.GetString("rclone.bearer"),
+
+		CACert:     expandPath(v.GetString("rclone.ca_cert")),
+		ClientCert: expandPath(v.GetString("rclone.client_cert")),
+		ClientKey:  expandPath(v.GetString("rclone.client_key")),
+
+		ConnectTimeout:  v.GetDuration("rclone.connect_timeout"),
+		ResponseTimeout: v.GetDuration("rclone.response_timeout"),
+
+		Spawn:      v.GetBool("rclone.spawn"),
+		ConfigFile: expandPath(v.GetString("rclone.config")),
 	}
 
 	return cfg, nil
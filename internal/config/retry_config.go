@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type RetryConfig struct {
+	MaxAttempts    int           // attempts of a storage operation, 1 disables retries
+	InitialBackoff time.Duration // wait before the first retry, doubled after every attempt
+	MaxBackoff     time.Duration // upper bound of the wait between attempts
+}
+
+func LoadRetryConfig() (*RetryConfig, error) {
+	viper.SetDefault("retry.max_attempts", 5)
+	viper.SetDefault("retry.initial_backoff", time.Second)
+	viper.SetDefault("retry.max_backoff", 30*time.Second)
+
+	cfg := &RetryConfig{
+		MaxAttempts:    viper.GetInt("retry.max_attempts"),
+		InitialBackoff: viper.GetDuration("retry.initial_backoff"),
+		MaxBackoff:     viper.GetDuration("retry.max_backoff"),
+	}
+
+	if cfg.MaxAttempts < 1 {
+		return nil, fmt.Errorf("retry.max_attempts must be at least 1")
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		return nil, fmt.Errorf("retry.max_backoff must not be lower than retry.initial_backoff")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+type S3Config struct {
+	Endpoint  string // eg : https://s3.eu-west-1.amazonaws.com or http://localhost:9000 (MinIO)
+	Region    string
+	Bucket    string
+	Prefix    string // key prefix, eg : db-backup/
+	PathStyle bool   // bucket in the path rather than in the host name, required by MinIO
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	StorageClass string // eg : STANDARD_IA, GLACIER_IR
+	SSE          string // server-side encryption, AES256 or aws:kms
+	SSEKMSKeyID  string
+	PartSize     int64 // multipart upload part size
+}
+
+func loadS3Config(v *viper.Viper) (*S3Config, error) {
+	v.SetDefault("s3.region", "us-east-1")
+	v.SetDefault("s3.part_size", "16MB")
+
+	cfg := &S3Config{
+		Endpoint:  v.GetString("s3.endpoint"),
+		Region:    v.GetString("s3.region"),
+		Bucket:    v.GetString("s3.bucket"),
+		Prefix:    v.GetString("s3.prefix"),
+		PathStyle: v.GetBool("s3.path_style"),
+
+		AccessKeyID:     v.GetString("s3.access_key_id"),
+		SecretAccessKey: v.GetString("s3.secret_access_key"),
+		SessionToken:    v.GetString("s3.session_token"),
+
+		StorageClass: v.GetString("s3.storage_class"),
+		SSE:          v.GetString("s3.sse"),
+		SSEKMSKeyID:  v.GetString("s3.sse_kms_key_id"),
+		PartSize:     int64(v.GetSizeInBytes("s3.part_size")),
+	}
+
+	// fall back to the standard AWS environment variables
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type SFTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+
+	// Password or KeyFile (with an optional KeyPassphrase) authenticates the user.
+	Password      string
+	KeyFile       string
+	KeyPassphrase string
+
+	KnownHosts string // known_hosts file the server key is checked against
+	Path       string // base directory backups are stored in
+}
+
+func loadSFTPConfig(v *viper.Viper) (*SFTPConfig, error) {
+	v.SetDefault("sftp.port", "22")
+	v.SetDefault("sftp.known_hosts", "~/.ssh/known_hosts")
+
+	cfg := &SFTPConfig{
+		Host:     v.GetString("sftp.host"),
+		Port:     v.GetString("sftp.port"),
+		Username: v.GetString("sftp.username"),
+
+		Password:      v.GetString("sftp.password"),
+		KeyFile:       expandPath(v.GetString("sftp.key_file")),
+		KeyPassphrase: v.GetString("sftp.key_passphrase"),
+
+		KnownHosts: expandPath(v.GetString("sftp.known_hosts")),
+		Path:       v.GetString("sftp.path"),
+	}
+
+	return cfg, nil
+}
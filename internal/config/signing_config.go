@@ -0,0 +1,24 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type SigningConfig struct {
+	PrivateKey       string   // ed25519 private key (PEM) used to sign backups
+	TrustedKeys      []string // ed25519 public keys (PEM) accepted on restore
+	RequireSignature bool     // refuse unsigned or tampered backups
+}
+
+func LoadSigningConfig() (*SigningConfig, error) {
+	cfg := &SigningConfig{
+		PrivateKey:       expandPath(viper.GetString("signing.private_key")),
+		RequireSignature: viper.GetBool("signing.require_signature"),
+	}
+
+	for _, k := range viper.GetStringSlice("signing.trusted_keys") {
+		cfg.TrustedKeys = append(cfg.TrustedKeys, expandPath(k))
+	}
+
+	return cfg, nil
+}
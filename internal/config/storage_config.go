@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+type StorageConfig struct {
+	Name   string // target name, eg : offsite
+	Type   string // rclone, local, s3, gcs, sftp, azure or webdav
+	Layout string // plain or chunked (deduplicated)
+
+	// MaxVolumeSize splits larger backups into volumes of this size, 0
+	// disables splitting.
+	MaxVolumeSize int64
+
+	Rclone *RCloneConfig
+	Local  *LocalConfig
+	S3     *S3Config
+	GCS    *GCSConfig
+	SFTP   *SFTPConfig
+	Azure  *AzureConfig
+	WebDAV *WebDAVConfig
+
+	// Targets replicate every backup to several storages, each one overriding
+	// the settings above. Empty stores backups in this storage only.
+	Targets []*StorageConfig
+
+	// MinReplicas is how many targets a backup must reach, 0 requires all of
+	// them.
+	MinReplicas int
+
+	// Remotes are storages backups are only copied to or synced with on
+	// demand, eg : a long-term archive.
+	Remotes []*StorageConfig
+}
+
+func LoadStorageConfig() (*StorageConfig, error) {
+	cfg, err := loadStorageConfig(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	if cfg.Targets, err = loadStorageTargets("storage.targets", names); err != nil {
+		return nil, err
+	}
+	if cfg.Remotes, err = loadStorageTargets("storage.remotes", names); err != nil {
+		return nil, err
+	}
+
+	cfg.MinReplicas = viper.GetInt("storage.min_replicas")
+	if cfg.MinReplicas < 0 || cfg.MinReplicas > len(cfg.Targets) {
+		return nil, fmt.Errorf("storage.min_replicas must be between 0 and the number of targets (%d)", len(cfg.Targets))
+	}
+
+	return cfg, nil
+}
+
+// LoadStorageTarget returns the storage target or remote called name, or the
+// configured storage when name is empty.
+func LoadStorageTarget(name string) (*StorageConfig, error) {
+	cfg, err := LoadStorageConfig()
+	if err != nil || name == "" {
+		return cfg, err
+	}
+
+	for _, target := range append(cfg.Targets, cfg.Remotes...) {
+		if target.Name == name {
+			return target, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown storage target: %s", name)
+}
+
+// Types returns the storage type of every target, or the configured type
+// without replication.
+func (c *StorageConfig) Types() []string {
+	if len(c.Targets) == 0 {
+		return []string{c.Type}
+	}
+
+	types := make([]string, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		types = append(types, t.Type)
+	}
+	return types
+}
+
+// loadStorageTargets loads the list of storage targets under key, names
+// holds the names already in use.
+func loadStorageTargets(key string, names map[string]bool) ([]*StorageConfig, error) {
+	list, ok := viper.Get(key).([]any)
+	if viper.IsSet(key) && !ok {
+		return nil, fmt.Errorf("%s must be a list", key)
+	}
+
+	var targets []*StorageConfig
+	for i, t := range list {
+		m, ok := t.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d] must be a map", key, i)
+		}
+
+		target, err := loadStorageTarget(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", key, i, err)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("%s[%d]: duplicate name %s", key, i, target.Name)
+		}
+		names[target.Name] = true
+
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// loadStorageTarget loads a storage target or remote, its settings override the
+// top level ones, eg : a target with `sftp: {path: /srv/offsite}` keeps the
+// other sftp settings.
+func loadStorageTarget(target map[string]any) (*StorageConfig, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, err
+	}
+
+	for key, value := range target {
+		switch key {
+		case "name":
+		case "type", "max_volume_size":
+			v.Set("storage."+key, value)
+		case "layout":
+			// the archive is compressed and encrypted once for every target
+			return nil, fmt.Errorf("layout can't be set per target, use storage.layout")
+		default:
+			if err := v.MergeConfigMap(map[string]any{key: value}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cfg, err := loadStorageConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Name, _ = target["name"].(string)
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	return cfg, nil
+}
+
+func loadStorageConfig(v *viper.Viper) (*StorageConfig, error) {
+	v.SetDefault("storage.type", "rclone")
+	v.SetDefault("storage.layout", "plain")
+
+	rclone, err := loadRCloneConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := loadLocalConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	s3, err := loadS3Config(v)
+	if err != nil {
+		return nil, err
+	}
+
+	gcs, err := loadGCSConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	sftp, err := loadSFTPConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	azure, err := loadAzureConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	webdav, err := loadWebDAVConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &StorageConfig{
+		Type:   v.GetString("storage.type"),
+		Layout: v.GetString("storage.layout"),
+		Rclone: rclone,
+		Local:  local,
+		S3:     s3,
+		GCS:    gcs,
+		SFTP:   sftp,
+		Azure:  azure,
+		WebDAV: webdav,
+
+		MaxVolumeSize: int64(v.GetSizeInBytes("storage.max_volume_size")),
+	}
+
+	return cfg, nil
+}
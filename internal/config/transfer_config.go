@@ -0,0 +1,19 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type TransferConfig struct {
+	// BWLimit limits the upload and download rate of the storages, in the
+	// rclone --bwlimit syntax, eg : 10M or "08:00,512k 18:00,off".
+	BWLimit string
+}
+
+func LoadTransferConfig() (*TransferConfig, error) {
+	cfg := &TransferConfig{
+		BWLimit: viper.GetString("transfer.bwlimit"),
+	}
+
+	return cfg, nil
+}
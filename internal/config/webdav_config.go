@@ -0,0 +1,21 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+type WebDAVConfig struct {
+	URL      string // collection backups are stored in, eg : https://cloud.example.com/remote.php/dav/files/backup/db
+	Username string
+	Password string // basic or digest, whichever the server asks for
+}
+
+func loadWebDAVConfig(v *viper.Viper) (*WebDAVConfig, error) {
+	cfg := &WebDAVConfig{
+		URL:      v.GetString("webdav.url"),
+		Username: v.GetString("webdav.username"),
+		Password: v.GetString("webdav.password"),
+	}
+
+	return cfg, nil
+}
@@ -2,34 +2,233 @@ package deps
 
 import (
 	"context"
+	"ez-snapshot/internal/archive"
 	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/encryption"
 	"ez-snapshot/internal/repository/backup"
 	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"slices"
 )
 
-func NewBackupRepo(_ context.Context) backup.Repository {
+func NewBackupRepo(ctx context.Context) backup.Repository {
 	cfg, err := config.LoadMySQLConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	return backup.New(
+	storageCfg, err := config.LoadStorageConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	binlogCfg := NewBinlogConfig(ctx)
+
+	codec, level := newCodec(ctx)
+	encryptor, decryptors := newCiphers(ctx)
+
+	// the chunked layout compresses and encrypts every chunk itself, doing it
+	// on the whole archive would defeat deduplication
+	if storageCfg.Layout == string(storage.Chunked) {
+		codec, level, encryptor = archive.None, 0, nil
+	}
+
+	opts := []backup.DbOpts{
 		backup.WithDbType(backup.MYSQL),
 		backup.WithDbHost(cfg.Host),
 		backup.WithDbPort(cfg.Port),
 		backup.WithDbUsername(cfg.Username),
 		backup.WithDbPassword(cfg.Password),
 		backup.WithDatabase(cfg.Database),
-	)
+		backup.WithCompression(codec),
+		backup.WithCompressionLevel(level),
+		backup.WithDecryptors(decryptors...),
+		backup.WithChecksumTables(cfg.ChecksumTables),
+		backup.WithBinlogCoordinates(binlogCfg.Enabled),
+	}
+
+	if encryptor != nil {
+		opts = append(opts, backup.WithEncryptor(encryptor))
+	}
+
+	return backup.New(opts...)
 }
 
 func NewStorageRepo(ctx context.Context) storage.Repository {
-	cfg, err := config.LoadRCloneConfig()
+	return NewStorageTarget(ctx, "")
+}
+
+// NewStorageTarget returns the repository of the storage target or remote
+// called name, or of the configured storage when name is empty.
+func NewStorageTarget(ctx context.Context, name string) storage.Repository {
+	cfg, err := config.LoadStorageTarget(name)
+	if err != nil {
+		panic(err)
+	}
+
+	retryCfg, err := config.LoadRetryConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	transferCfg, err := config.LoadTransferConfig()
 	if err != nil {
 		panic(err)
 	}
-	return storage.New(
+
+	bwlimit, err := storage.ParseBandwidthLimit(transferCfg.BWLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	codec, level := newCodec(ctx)
+	encryptor, decryptors := newCiphers(ctx)
+
+	repo, err := storage.New(
 		ctx,
 		cfg,
+		storage.WithChunkCompression(codec, level),
+		storage.WithChunkEncryption(encryptor, decryptors...),
+		storage.WithRetry(retryCfg.MaxAttempts, retryCfg.InitialBackoff, retryCfg.MaxBackoff),
+		storage.WithBandwidthLimit(bwlimit),
 	)
+	if err != nil {
+		panic(err)
+	}
+	return repo
+}
+
+func newCodec(_ context.Context) (archive.Codec, int) {
+	cfg, err := config.LoadArchiveConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	codec, err := archive.ParseCodec(cfg.Compression)
+	if err != nil {
+		panic(err)
+	}
+	return codec, cfg.Level
+}
+
+// newCiphers returns the configured encryptor, if any, and every decryptor
+// archives can be restored with.
+func newCiphers(_ context.Context) (encryption.Encryptor, []encryption.Decryptor) {
+	cfg, err := config.LoadEncryptionConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	var encryptor encryption.Encryptor
+	var decryptors []encryption.Decryptor
+
+	var cipher *encryption.SymmetricCipher
+	switch {
+	case cfg.Passphrase != "":
+		cipher = encryption.NewPassphrase(cfg.Passphrase)
+	case cfg.KeyFile != "":
+		cipher, err = encryption.NewKeyFile(cfg.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if cipher != nil {
+		encryptor = cipher
+		decryptors = append(decryptors, cipher)
+	}
+
+	if len(cfg.Recipients) > 0 {
+		recipients, err := encryption.NewAgeRecipients(cfg.Recipients)
+		if err != nil {
+			panic(err)
+		}
+		encryptor = recipients
+	}
+
+	if cfg.IdentityFile != "" {
+		identity, err := encryption.NewAgeIdentityFile(cfg.IdentityFile)
+		if err != nil {
+			panic(err)
+		}
+		decryptors = append(decryptors, identity)
+	}
+
+	return encryptor, decryptors
+}
+
+func NewSignaturePolicy(_ context.Context) *signature.Policy {
+	cfg, err := config.LoadSigningConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	policy, err := signature.NewPolicy(cfg.PrivateKey, cfg.TrustedKeys, cfg.RequireSignature)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func NewBinlogConfig(_ context.Context) *config.BinlogConfig {
+	cfg, err := config.LoadBinlogConfig()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// NewStorageTypes returns the type of every storage backups are stored in.
+func NewStorageTypes(_ context.Context) []storage.StorageType {
+	cfg, err := config.LoadStorageConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	var storageTypes []storage.StorageType
+	for _, t := range cfg.Types() {
+		storageType, err := storage.ParseStorageType(t)
+		if err != nil {
+			panic(err)
+		}
+		storageTypes = append(storageTypes, storageType)
+	}
+	return storageTypes
+}
+
+// NewRCloneDaemon starts rclone rcd when rclone.spawn is set and rclone.host
+// is not reachable, and points the rclone config at it. It returns nil when
+// no daemon was started.
+func NewRCloneDaemon(ctx context.Context) *storage.RCloneDaemon {
+	cfg, err := config.LoadStorageConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	// remotes are only used by copy and sync, but they may need the daemon too
+	types := cfg.Types()
+	for _, remote := range cfg.Remotes {
+		types = append(types, remote.Type)
+	}
+
+	if !slices.Contains(types, string(storage.Rclone)) || !cfg.Rclone.Spawn || storage.RCloneReachable(ctx, cfg.Rclone) {
+		return nil
+	}
+
+	fmt.Printf("rclone rc API is not reachable on %s, starting rclone rcd...\n", cfg.Rclone.Host)
+	daemon, err := storage.SpawnRClone(ctx, cfg.Rclone)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("✅ rclone rcd is running on %s\n", daemon.Host)
+
+	config.Set("rclone.host", daemon.Host)
+	config.Set("rclone.username", daemon.Username)
+	config.Set("rclone.password", daemon.Password)
+	config.Set("rclone.bearer", "")
+	config.Set("rclone.ca_cert", "")
+	config.Set("rclone.client_cert", "")
+	config.Set("rclone.client_key", "")
+
+	return daemon
 }
@@ -0,0 +1,90 @@
+package encryption
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+var ageMagic = []byte("age-encryption.org/v1\n")
+
+// AgeCipher encrypts archives to age X25519 recipients. Hosts that only take
+// backups need the public recipients, restoring requires an identity file
+// holding one of the matching private keys.
+type AgeCipher struct {
+	recipients    []age.Recipient
+	recipientKeys []string
+	identities    []age.Identity
+}
+
+// NewAgeRecipients returns a cipher encrypting to the given age public keys,
+// eg : age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+func NewAgeRecipients(recipients []string) (*AgeCipher, error) {
+	parsed, err := age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient: %w", err)
+	}
+	keys := make([]string, len(recipients))
+	for i, r := range recipients {
+		keys[i] = strings.TrimSpace(r)
+	}
+	sort.Strings(keys)
+	return &AgeCipher{recipients: parsed, recipientKeys: keys}, nil
+}
+
+// NewAgeIdentityFile returns a cipher decrypting with the private keys in
+// path, the format is the one written by age-keygen.
+func NewAgeIdentityFile(path string) (*AgeCipher, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity file %s: %w", path, err)
+	}
+	return &AgeCipher{identities: identities}, nil
+}
+
+func (a *AgeCipher) Scheme() Scheme {
+	return Age
+}
+
+func (a *AgeCipher) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if len(a.recipients) == 0 {
+		return nil, errors.New("no age recipients configured")
+	}
+	return age.Encrypt(w, a.recipients...)
+}
+
+// ChunkKey derives the chunk naming key from the recipients, hosts taking
+// backups have no private key. Chunk names are hidden from anyone who
+// doesn't know the public keys.
+func (a *AgeCipher) ChunkKey() ([]byte, error) {
+	if len(a.recipientKeys) == 0 {
+		return nil, errors.New("no age recipients configured")
+	}
+	return hkdf.Key(sha256.New, []byte(strings.Join(a.recipientKeys, "\n")), nil, "ez-snapshot chunk names", 32)
+}
+
+func (a *AgeCipher) Decrypt(r io.Reader) (io.Reader, error) {
+	if len(a.identities) == 0 {
+		return nil, errors.New("no age identity configured, set encryption.identity_file or pass --identity")
+	}
+
+	dr, err := age.Decrypt(r, a.identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, errors.New("backup was not encrypted to any key of the configured identity file")
+	}
+	return dr, err
+}
@@ -0,0 +1,64 @@
+package encryption
+
+import (
+	"bytes"
+	"io"
+)
+
+// Scheme identifies how an archive has been encrypted.
+type Scheme string
+
+const (
+	Symmetric Scheme = "aes-256-gcm"
+	Age       Scheme = "age"
+)
+
+// PeekSize is the number of leading bytes Detect needs to see.
+const PeekSize = 32
+
+// Ext returns the file extension appended to archives encrypted with the scheme.
+func (s Scheme) Ext() string {
+	switch s {
+	case Symmetric:
+		return ".enc"
+	case Age:
+		return ".age"
+	default:
+		return ""
+	}
+}
+
+// Encryptor encrypts archives before they are uploaded.
+type Encryptor interface {
+	Scheme() Scheme
+	// Encrypt wraps w, closing the returned writer finalizes the stream
+	// but leaves w open.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+}
+
+// ChunkKeyer is implemented by the encryptors deriving a key to name the
+// chunks of the chunked layout, so stored names don't reveal the hash of
+// the plain content. The key is the same on every host sharing the
+// encryption settings.
+type ChunkKeyer interface {
+	ChunkKey() ([]byte, error)
+}
+
+// Decryptor decrypts archives written by the matching Encryptor.
+type Decryptor interface {
+	Scheme() Scheme
+	Decrypt(r io.Reader) (io.Reader, error)
+}
+
+// Detect returns the scheme of an encrypted stream from its first bytes, or
+// an empty scheme when the stream isn't encrypted.
+func Detect(peek []byte) Scheme {
+	switch {
+	case bytes.HasPrefix(peek, symmetricMagic):
+		return Symmetric
+	case bytes.HasPrefix(peek, ageMagic):
+		return Age
+	default:
+		return ""
+	}
+}
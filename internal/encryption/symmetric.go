@@ -0,0 +1,329 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Stream format:
+//
+//	header: magic (8) | kdf (1) | scrypt log2(N) (1) | kdf salt (16) | stream salt (16)
+//	body:   AES-256-GCM sealed chunks of up to chunkSize bytes of plaintext
+//
+// The master key is derived from the passphrase (scrypt with the kdf salt) or
+// the key file, and every stream is encrypted with its own key derived from
+// the master key and the random stream salt (HKDF-SHA256). The kdf salt is
+// reused within a process so many small streams, eg : the chunked layout,
+// only pay for scrypt once.
+//
+// Each chunk nonce is an 11 byte big-endian counter followed by a byte that
+// is 1 for the final chunk, so reordered, dropped or truncated chunks fail to
+// authenticate. The header is used as additional data of every chunk.
+var symmetricMagic = []byte("EZSNAPE1")
+
+const (
+	kdfScrypt  byte = 1
+	kdfKeyFile byte = 2
+
+	scryptLogN = 15
+	saltSize   = 16
+	headerSize = 8 + 1 + 1 + 2*saltSize
+	chunkSize  = 64 * 1024
+
+	// maxScryptLogN bounds the work factor read from the unauthenticated
+	// header, 20 already needs 1 GiB of memory.
+	maxScryptLogN = 20
+
+	// minKeyFileSize is the size of the key file content once trimmed, eg :
+	// openssl rand -base64 32 writes 44 bytes.
+	minKeyFileSize = 32
+)
+
+// SymmetricCipher encrypts archives with a key derived from a passphrase
+// (scrypt) or from the content of a key file (HKDF-SHA256).
+type SymmetricCipher struct {
+	passphrase []byte
+	keyFile    []byte
+
+	mu         sync.Mutex
+	kdfSalt    []byte            // salt used for every stream encrypted by this process
+	masterKeys map[string][]byte // scrypt results by kdf salt
+}
+
+// NewPassphrase returns a cipher deriving its key from passphrase.
+func NewPassphrase(passphrase string) *SymmetricCipher {
+	return &SymmetricCipher{passphrase: []byte(passphrase)}
+}
+
+// NewKeyFile returns a cipher deriving its key from the content of path.
+func NewKeyFile(path string) (*SymmetricCipher, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) < minKeyFileSize {
+		return nil, fmt.Errorf("key file %s is too short, use at least %d random bytes", path, minKeyFileSize)
+	}
+	return &SymmetricCipher{keyFile: b}, nil
+}
+
+func (s *SymmetricCipher) Scheme() Scheme {
+	return Symmetric
+}
+
+func (s *SymmetricCipher) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	header := make([]byte, headerSize)
+	copy(header, symmetricMagic)
+	header[8] = kdfScrypt
+	if s.keyFile != nil {
+		header[8] = kdfKeyFile
+	}
+	header[9] = scryptLogN
+
+	s.mu.Lock()
+	if s.kdfSalt == nil {
+		s.kdfSalt = make([]byte, saltSize)
+		if _, err := rand.Read(s.kdfSalt); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	copy(header[10:], s.kdfSalt)
+	s.mu.Unlock()
+
+	if _, err := rand.Read(header[10+saltSize:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := s.aead(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &chunkWriter{w: w, aead: aead, ad: header, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (s *SymmetricCipher) Decrypt(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !bytes.Equal(header[:8], symmetricMagic) {
+		return nil, errors.New("not an ez-snapshot encrypted archive")
+	}
+
+	aead, err := s.aead(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{r: r, aead: aead, ad: header, buf: make([]byte, chunkSize+aead.Overhead()+1)}, nil
+}
+
+// chunkKeySalt is the scrypt salt of the chunk naming key, it has to be the
+// same for every process.
+var chunkKeySalt = []byte("ez-snapshot chunk names")
+
+// ChunkKey derives the chunk naming key from the passphrase or key file.
+func (s *SymmetricCipher) ChunkKey() ([]byte, error) {
+	kdf := kdfScrypt
+	if s.keyFile != nil {
+		kdf = kdfKeyFile
+	}
+	master, err := s.masterKey(kdf, scryptLogN, chunkKeySalt)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, master, nil, "ez-snapshot chunk names", 32)
+}
+
+// aead derives the stream key described by header.
+func (s *SymmetricCipher) aead(header []byte) (cipher.AEAD, error) {
+	master, err := s.masterKey(header[8], header[9], header[10:10+saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, master, header[10+saltSize:], "ez-snapshot archive key", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// masterKey derives the master key, scrypt results are cached by salt.
+func (s *SymmetricCipher) masterKey(kdf, logN byte, salt []byte) ([]byte, error) {
+	switch kdf {
+	case kdfScrypt:
+		if s.passphrase == nil {
+			return nil, errors.New("archive is encrypted with a passphrase but encryption.passphrase is not configured")
+		}
+		if logN < 10 || logN > maxScryptLogN {
+			return nil, fmt.Errorf("invalid scrypt work factor: %d", logN)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		cacheKey := fmt.Sprintf("%d:%x", logN, salt)
+		if key, ok := s.masterKeys[cacheKey]; ok {
+			return key, nil
+		}
+		key, err := scrypt.Key(s.passphrase, salt, 1<<logN, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+		if s.masterKeys == nil {
+			s.masterKeys = make(map[string][]byte)
+		}
+		s.masterKeys[cacheKey] = key
+		return key, nil
+	case kdfKeyFile:
+		if s.keyFile == nil {
+			return nil, errors.New("archive is encrypted with a key file but encryption.key_file is not configured")
+		}
+		return s.keyFile, nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation: %d", kdf)
+	}
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// chunkWriter buffers one chunk so the final chunk can be flagged on Close.
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("write to closed encryption stream")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		if len(cw.buf) == chunkSize {
+			if err := cw.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(cw.buf[len(cw.buf):chunkSize], p)
+		cw.buf = cw.buf[:len(cw.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (cw *chunkWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	return cw.flush(true)
+}
+
+func (cw *chunkWriter) flush(last bool) error {
+	sealed := cw.aead.Seal(nil, chunkNonce(cw.counter, last), cw.buf, cw.ad)
+	cw.counter++
+	cw.buf = cw.buf[:0]
+	_, err := cw.w.Write(sealed)
+	return err
+}
+
+// chunkReader reads one byte past every chunk to find out whether it is the
+// final one.
+type chunkReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	pending int // bytes of the next chunk already in buf
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.plain) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.plain)
+	cr.plain = cr.plain[n:]
+	return n, nil
+}
+
+func (cr *chunkReader) next() error {
+	n, err := io.ReadFull(cr.r, cr.buf[cr.pending:])
+	n += cr.pending
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+
+	sealed := cr.buf[:n]
+	if !last {
+		// the extra byte belongs to the next chunk
+		sealed = cr.buf[:n-1]
+	}
+	if len(sealed) < cr.aead.Overhead() {
+		return errors.New("encrypted archive is truncated")
+	}
+
+	plain, err := cr.aead.Open(nil, chunkNonce(cr.counter, last), sealed, cr.ad)
+	if err != nil {
+		return errors.New("failed to decrypt archive: wrong key, truncated or corrupted data")
+	}
+	cr.counter++
+	cr.plain = plain
+	cr.done = last
+
+	if !last {
+		cr.buf[0] = cr.buf[n-1]
+		cr.pending = 1
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package encryption
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sealedChunkSize = chunkSize + 16 // GCM tag
+
+func newTestKeyFile(t *testing.T, content string) *SymmetricCipher {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func encrypt(t *testing.T, c Encryptor, plain []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(c Decryptor, sealed []byte) ([]byte, error) {
+	r, err := c.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func testContent(size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(b)
+	return b
+}
+
+func TestSymmetricRoundTrip(t *testing.T) {
+	ciphers := map[string]*SymmetricCipher{
+		"passphrase": NewPassphrase("correct horse battery staple"),
+		"key file":   newTestKeyFile(t, strings.Repeat("k", minKeyFileSize)+"\n"),
+	}
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17}
+
+	for name, c := range ciphers {
+		for _, size := range sizes {
+			plain := testContent(size)
+			sealed := encrypt(t, c, plain)
+
+			// an empty stream still has a sealed final chunk
+			chunks := max((size+chunkSize-1)/chunkSize, 1)
+			if want := headerSize + chunks*16 + size; len(sealed) != want {
+				t.Errorf("%s: %d bytes encrypted to %d bytes, want %d", name, size, len(sealed), want)
+			}
+			if Detect(sealed) != Symmetric {
+				t.Errorf("%s: Detect doesn't recognize the stream", name)
+			}
+
+			got, err := decrypt(c, sealed)
+			if err != nil {
+				t.Errorf("%s: decrypt %d bytes: %v", name, size, err)
+				continue
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("%s: decrypted %d bytes differ from the %d encrypted", name, len(got), size)
+			}
+		}
+	}
+}
+
+func TestSymmetricTampering(t *testing.T) {
+	c := NewPassphrase("correct horse battery staple")
+	plain := testContent(3*chunkSize + 17)
+	sealed := encrypt(t, c, plain)
+
+	chunk := func(i int) []byte {
+		start := headerSize + i*sealedChunkSize
+		return sealed[start:min(start+sealedChunkSize, len(sealed))]
+	}
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	header := sealed[:headerSize]
+
+	tests := []struct {
+		name   string
+		sealed []byte
+	}{
+		{"header only", header},
+		{"truncated header", sealed[:headerSize-1]},
+		{"truncated mid chunk", sealed[:len(sealed)-5]},
+		{"final chunk dropped", concat(header, chunk(0), chunk(1), chunk(2))},
+		{"first chunk dropped", concat(header, chunk(1), chunk(2), chunk(3))},
+		{"middle chunk dropped", concat(header, chunk(0), chunk(2), chunk(3))},
+		{"chunks reordered", concat(header, chunk(1), chunk(0), chunk(2), chunk(3))},
+		{"chunk duplicated", concat(header, chunk(0), chunk(0), chunk(1), chunk(2), chunk(3))},
+		{"flipped byte", concat(header, chunk(0), chunk(1), append([]byte{chunk(2)[0] ^ 1}, chunk(2)[1:]...), chunk(3))},
+		{"stream salt changed", concat(append(header[:headerSize-1:headerSize-1], header[headerSize-1]^1), sealed[headerSize:])},
+		{"trailing garbage", concat(sealed, []byte{0})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decrypt(c, tt.sealed)
+			if err == nil {
+				t.Fatalf("decrypted %d bytes, want an error", len(got))
+			}
+		})
+	}
+}
+
+func TestSymmetricWrongKey(t *testing.T) {
+	plain := testContent(chunkSize + 1)
+	keyFile := strings.Repeat("k", minKeyFileSize)
+
+	tests := []struct {
+		name    string
+		encrypt *SymmetricCipher
+		decrypt *SymmetricCipher
+		wantErr string
+	}{
+		{
+			name:    "wrong passphrase",
+			encrypt: NewPassphrase("correct horse battery staple"),
+			decrypt: NewPassphrase("correct horse battery stapler"),
+			wantErr: "failed to decrypt archive",
+		},
+		{
+			name:    "wrong key file",
+			encrypt: newTestKeyFile(t, keyFile),
+			decrypt: newTestKeyFile(t, strings.Repeat("x", minKeyFileSize)),
+			wantErr: "failed to decrypt archive",
+		},
+		{
+			name:    "key file instead of passphrase",
+			encrypt: NewPassphrase("correct horse battery staple"),
+			decrypt: newTestKeyFile(t, keyFile),
+			wantErr: "encryption.passphrase is not configured",
+		},
+		{
+			name:    "passphrase instead of key file",
+			encrypt: newTestKeyFile(t, keyFile),
+			decrypt: NewPassphrase(keyFile),
+			wantErr: "encryption.key_file is not configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decrypt(tt.decrypt, encrypt(t, tt.encrypt, plain))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("decrypt error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewKeyFileTooShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	// trailing whitespace doesn't count
+	if err := os.WriteFile(path, []byte(strings.Repeat("k", minKeyFileSize-1)+"\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyFile(path); err == nil {
+		t.Fatal("NewKeyFile accepted a short key file")
+	}
+}
+
+func TestSymmetricWorkFactor(t *testing.T) {
+	c := NewPassphrase("correct horse battery staple")
+	sealed := encrypt(t, c, testContent(1))
+
+	tests := []struct {
+		logN    byte
+		wantErr bool
+	}{
+		{scryptLogN, false},
+		{9, true},
+		{maxScryptLogN + 1, true},
+		{255, true},
+	}
+
+	for _, tt := range tests {
+		tampered := bytes.Clone(sealed)
+		tampered[9] = tt.logN
+		_, err := c.Decrypt(bytes.NewReader(tampered))
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "invalid scrypt work factor")) {
+			t.Errorf("log2(N) %d: Decrypt error = %v, want an invalid work factor", tt.logN, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("log2(N) %d: %v", tt.logN, err)
+		}
+	}
+}
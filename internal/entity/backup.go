@@ -3,11 +3,32 @@ package entity
 import "time"
 
 type Backup struct {
-	Path     string    `json:"Path"`
-	Name     string    `json:"Name"`
-	Size     int64     `json:"Size"`
-	MimeType string    `json:"MimeType"`
-	ModTime  time.Time `json:"ModTime"`
-	IsDir    bool      `json:"IsDir"`
-	Tier     string    `json:"Tier"`
+	Path     string
+	Name     string
+	Size     int64
+	MimeType string
+	ModTime  time.Time
+	IsDir    bool
+	Tier     string
+
+	// Metadata was attached to the object when it was uploaded, nil when
+	// the storage listing doesn't return it.
+	Metadata map[string]string
+
+	// Database, Engine and CreatedAt come from the metadata, or from the
+	// backup name when it has none.
+	Database  string
+	Engine    string
+	CreatedAt time.Time
+	// Checksum is the hex SHA-256 of the backup, empty when unknown.
+	Checksum string
+	Tags     map[string]string
+
+	// Signature is the outcome of the signature verification, empty when
+	// no trusted keys are configured.
+	Signature string
+
+	// Replicas are the storage targets holding the backup, empty without
+	// replication.
+	Replicas []string
 }
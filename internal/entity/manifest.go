@@ -0,0 +1,96 @@
+package entity
+
+import "time"
+
+// ManifestName is the name of the manifest entry inside every archive.
+const ManifestName = "manifest.json"
+
+const (
+	// FullBackup archives hold every table of the database.
+	FullBackup = "full"
+	// DifferentialBackup archives only hold the tables that changed since
+	// their base full backup.
+	DifferentialBackup = "differential"
+)
+
+// Manifest describes where an archive comes from and what it contains. It is
+// stored as the first entry of the archive so it can be read without
+// downloading the whole backup.
+type Manifest struct {
+	Version int `json:"version"`
+
+	Engine          string `json:"engine"`  // eg : mysql
+	DBType          int    `json:"db_type"` // backup.DBType
+	ServerVersion   string `json:"server_version"`
+	ToolVersion     string `json:"tool_version"`      // ez-snapshot version
+	DumpToolVersion string `json:"dump_tool_version"` // eg : mysqldump --version
+	Database        string `json:"database"`
+
+	CreatedAt   time.Time `json:"created_at"` // UTC
+	DumpOptions []string  `json:"dump_options"`
+
+	Kind         string   `json:"kind"`                    // FullBackup or DifferentialBackup
+	Base         string   `json:"base,omitempty"`          // name of the base full backup of a differential backup
+	DumpedTables []string `json:"dumped_tables,omitempty"` // tables held by a differential backup
+
+	Binlog *ManifestBinlog `json:"binlog,omitempty"` // where binary logs must be replayed from
+
+	Compression      string `json:"compression"`
+	CompressionLevel int    `json:"compression_level"`
+	Encryption       string `json:"encryption,omitempty"`
+
+	// FreshStats is set when the table statistics were read bypassing the
+	// information_schema cache of MySQL 8, cached ones may predate the last
+	// changes.
+	FreshStats bool `json:"fresh_stats,omitempty"`
+
+	Tables  []ManifestTable `json:"tables"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+type ManifestTable struct {
+	Name       string     `json:"name"`
+	Rows       int64      `json:"rows"` // approximate for InnoDB tables
+	DataSize   int64      `json:"data_size"`
+	IndexSize  int64      `json:"index_size"`
+	UpdateTime *time.Time `json:"update_time,omitempty"`
+	Checksum   string     `json:"checksum,omitempty"` // CHECKSUM TABLE, when enabled
+}
+
+// ManifestBinlog holds the binary log coordinates of the dump snapshot.
+type ManifestBinlog struct {
+	File     string `json:"file"`
+	Position int64  `json:"position"`
+	GTIDSet  string `json:"gtid_set,omitempty"` // GTIDs included in the dump
+}
+
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Entry returns the manifest entry with the given name.
+func (m *Manifest) Entry(name string) (ManifestEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// IsDifferential reports whether the archive needs its base backup to be restored.
+func (m *Manifest) IsDifferential() bool {
+	return m.Kind == DifferentialBackup
+}
+
+// Table returns the table with the given name.
+func (m *Manifest) Table(name string) (ManifestTable, bool) {
+	for _, t := range m.Tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return ManifestTable{}, false
+}
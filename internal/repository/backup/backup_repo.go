@@ -2,11 +2,24 @@ package backup
 
 import (
 	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
 	"io"
 )
 
+// ErrNoManifest is returned for archives written before manifests existed.
+var ErrNoManifest = errors.New("archive has no manifest")
+
 type Repository interface {
-	Dump(ctx context.Context) (string, error)
+	DatabaseName() string
+	// Dump returns the path of the archive and its manifest.
+	Dump(ctx context.Context) (string, *entity.Manifest, error)
+	// DumpDifferential dumps the tables changed since the full backup
+	// described by base.
+	DumpDifferential(ctx context.Context, base *entity.Manifest, baseName string) (string, *entity.Manifest, error)
 	Restore(ctx context.Context, reader io.ReadCloser) error
+	ReadManifest(ctx context.Context, reader io.ReadCloser) (*entity.Manifest, error)
+	Verify(ctx context.Context, reader io.Reader) error
 	DropAllTables(ctx context.Context) error
+	DropTables(ctx context.Context, tables []string) error
 }
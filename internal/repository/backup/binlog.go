@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"context"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BinlogRepository is implemented by engines able to archive and replay
+// their binary logs for point-in-time recovery.
+type BinlogRepository interface {
+	// FirstBinlog returns the oldest binary log still available on the server.
+	FirstBinlog(ctx context.Context) (string, error)
+	// StreamBinlogs copies the binary logs, starting with from, into dir
+	// until ctx is canceled.
+	StreamBinlogs(ctx context.Context, dir, from string) error
+	// LastBinlogEvent returns the time of the last complete event of a
+	// streamed binary log, zero when it holds none.
+	LastBinlogEvent(ctx context.Context, file string) (time.Time, error)
+	// PackBinlog compresses and encrypts a streamed binary log like archives
+	// are, into a temp file the caller must remove.
+	PackBinlog(ctx context.Context, file string) (string, error)
+	// UnpackBinlog reverses PackBinlog into dst.
+	UnpackBinlog(ctx context.Context, r io.Reader, dst string) error
+	// ReplayBinlogs applies the binary log files in order, starting at the
+	// position recorded by a full backup and stopping at target.
+	ReplayBinlogs(ctx context.Context, files []string, start *entity.ManifestBinlog, target RecoveryTarget) error
+}
+
+// RecoveryTarget is the point binary logs are replayed up to, either a time
+// or a GTID set.
+type RecoveryTarget struct {
+	Time    time.Time
+	GTIDSet string
+}
+
+// ParseRecoveryTarget accepts a local time (eg : 2026-10-18 14:03:00), an
+// RFC 3339 time (eg : 2026-10-18T14:03:00+07:00) or a GTID set (eg :
+// 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42).
+func ParseRecoveryTarget(v string) (RecoveryTarget, error) {
+	if t, err := time.ParseInLocation(time.DateTime, v, time.Local); err == nil {
+		return RecoveryTarget{Time: t}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return RecoveryTarget{Time: t.Local()}, nil
+	}
+	if _, err := ParseGTIDSet(v); err != nil || !strings.Contains(v, ":") {
+		return RecoveryTarget{}, fmt.Errorf("invalid recovery target %q, use \"YYYY-MM-DD HH:MM:SS\", RFC 3339 or a GTID set", v)
+	}
+	return RecoveryTarget{GTIDSet: v}, nil
+}
+
+func (t RecoveryTarget) String() string {
+	if t.GTIDSet != "" {
+		return t.GTIDSet
+	}
+	return t.Time.Format(time.DateTime)
+}
+
+// GTIDSet maps a source uuid (and tag) to its sorted, merged transaction ranges.
+type GTIDSet map[string][][2]int64
+
+// ParseGTIDSet parses a MySQL GTID set, eg : uuid:1-5:7,uuid2:1-3.
+func ParseGTIDSet(v string) (GTIDSet, error) {
+	set := make(GTIDSet)
+	v = strings.Join(strings.Fields(v), "")
+	if v == "" {
+		return set, nil
+	}
+
+	for _, part := range strings.Split(v, ",") {
+		fields := strings.Split(part, ":")
+		source := strings.ToLower(fields[0])
+		for _, f := range fields[1:] {
+			lo, hi, isRange := strings.Cut(f, "-")
+			start, err := strconv.ParseInt(lo, 10, 64)
+			if err != nil {
+				// a tag, eg : uuid:tag:1-5
+				source = strings.ToLower(fields[0]) + ":" + f
+				continue
+			}
+			end := start
+			if isRange {
+				if end, err = strconv.ParseInt(hi, 10, 64); err != nil || end < start {
+					return nil, fmt.Errorf("invalid GTID range %q", f)
+				}
+			}
+			set[source] = append(set[source], [2]int64{start, end})
+		}
+	}
+
+	for source, ranges := range set {
+		set[source] = mergeRanges(ranges)
+	}
+	return set, nil
+}
+
+func mergeRanges(ranges [][2]int64) [][2]int64 {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1]+1 {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Contains reports whether every transaction of other is in s.
+func (s GTIDSet) Contains(other GTIDSet) bool {
+	for source, ranges := range other {
+		for _, r := range ranges {
+			covered := false
+			for _, c := range s[source] {
+				if r[0] >= c[0] && r[1] <= c[1] {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+	}
+	return true
+}
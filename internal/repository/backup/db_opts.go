@@ -1,12 +1,23 @@
 package backup
 
+import (
+	"ez-snapshot/internal/archive"
+	"ez-snapshot/internal/encryption"
+)
+
 type dbOpts struct {
-	dbType   DBType
-	host     string
-	port     string
-	username string
-	password string
-	database string
+	dbType           DBType
+	host             string
+	port             string
+	username         string
+	password         string
+	database         string
+	compression      archive.Codec
+	compressionLevel int
+	encryptor        encryption.Encryptor
+	decryptors       []encryption.Decryptor
+	checksumTables   bool
+	recordBinlog     bool
 }
 
 type DbOpts func(*dbOpts)
@@ -46,3 +57,39 @@ func WithDatabase(database string) DbOpts {
 		o.database = database
 	}
 }
+
+func WithCompression(codec archive.Codec) DbOpts {
+	return func(o *dbOpts) {
+		o.compression = codec
+	}
+}
+
+func WithCompressionLevel(level int) DbOpts {
+	return func(o *dbOpts) {
+		o.compressionLevel = level
+	}
+}
+
+func WithEncryptor(encryptor encryption.Encryptor) DbOpts {
+	return func(o *dbOpts) {
+		o.encryptor = encryptor
+	}
+}
+
+func WithDecryptors(decryptors ...encryption.Decryptor) DbOpts {
+	return func(o *dbOpts) {
+		o.decryptors = append(o.decryptors, decryptors...)
+	}
+}
+
+func WithChecksumTables(enabled bool) DbOpts {
+	return func(o *dbOpts) {
+		o.checksumTables = enabled
+	}
+}
+
+func WithBinlogCoordinates(enabled bool) DbOpts {
+	return func(o *dbOpts) {
+		o.recordBinlog = enabled
+	}
+}
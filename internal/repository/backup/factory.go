@@ -1,11 +1,14 @@
 package backup
 
+import "ez-snapshot/internal/archive"
+
 func New(opts ...DbOpts) Repository {
 
 	o := dbOpts{
-		dbType: MYSQL,
-		host:   "localhost",
-		port:   "3306",
+		dbType:      MYSQL,
+		host:        "localhost",
+		port:        "3306",
+		compression: archive.Gzip,
 	}
 
 	// apply all user-provided options
@@ -20,6 +23,15 @@ func New(opts ...DbOpts) Repository {
 			Host:     o.host,
 			Port:     o.port,
 			Database: o.database,
+
+			Compression:      o.compression,
+			CompressionLevel: o.compressionLevel,
+
+			Encryptor:  o.encryptor,
+			Decryptors: o.decryptors,
+
+			RecordBinlog:   o.recordBinlog,
+			ChecksumTables: o.checksumTables,
 		}
 	}
 
@@ -2,14 +2,23 @@ package backup
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ez-snapshot/internal/archive"
+	"ez-snapshot/internal/encryption"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/version"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -20,54 +29,247 @@ type MySqlBackup struct {
 	Host     string
 	Port     string
 	Database string
+
+	Compression      archive.Codec
+	CompressionLevel int
+
+	Encryptor  encryption.Encryptor
+	Decryptors []encryption.Decryptor
+
+	// RecordBinlog dumps in a single transaction and records the binary log
+	// coordinates of the snapshot in the manifest.
+	RecordBinlog bool
+
+	// ChecksumTables runs CHECKSUM TABLE to detect changed tables for
+	// differential backups, instead of relying on information_schema.
+	ChecksumTables bool
 }
 
-func (m MySqlBackup) Dump(ctx context.Context) (string, error) {
-	// final tar.gz file
-	filename := fmt.Sprintf("%s_%s.tar.gz", m.Database, time.Now().Format("20060102_150405"))
+// DatabaseName returns the name of the backed up database.
+func (m MySqlBackup) DatabaseName() string {
+	return m.Database
+}
+
+func (m MySqlBackup) Dump(ctx context.Context) (string, *entity.Manifest, error) {
+	// collect what the manifest needs before dumping
+	manifest, err := m.newManifest(ctx, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	manifest.Kind = entity.FullBackup
+
+	path, err := m.dump(ctx, manifest, nil, "")
+	return path, manifest, err
+}
+
+// DumpDifferential only dumps the tables that changed since the base full
+// backup described by base.
+func (m MySqlBackup) DumpDifferential(ctx context.Context, base *entity.Manifest, baseName string) (string, *entity.Manifest, error) {
+	if base.IsDifferential() {
+		return "", nil, fmt.Errorf("%s is a differential backup, it can't be used as base", baseName)
+	}
+	if base.Database != m.Database {
+		return "", nil, fmt.Errorf("%s is a backup of %s, not %s", baseName, base.Database, m.Database)
+	}
+
+	manifest, err := m.newManifest(ctx, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	changed := changedTables(base, manifest)
+	manifest.Kind = entity.DifferentialBackup
+	manifest.Base = baseName
+	manifest.DumpedTables = changed
+
+	fmt.Printf("%d of %d table(s) changed since %s\n", len(changed), len(manifest.Tables), baseName)
+
+	path, err := m.dump(ctx, manifest, changed, "_diff")
+	return path, manifest, err
+}
+
+// changedTables returns the tables of current that may have changed since
+// base. Without checksums a table is considered changed when the statistics
+// of either manifest may come from a cache, when its update time, row count
+// or size differs, or when it has been updated around or after the time base
+// was taken, update times only have a second precision.
+func changedTables(base, current *entity.Manifest) []string {
+	changed := []string{}
+	for _, t := range current.Tables {
+		b, ok := base.Table(t.Name)
+		switch {
+		case !ok:
+		case t.Checksum != "" && b.Checksum != "":
+			if t.Checksum == b.Checksum {
+				continue
+			}
+		case !base.FreshStats || !current.FreshStats:
+		case t.UpdateTime == nil || b.UpdateTime == nil:
+		case !t.UpdateTime.Equal(*b.UpdateTime) || t.Rows != b.Rows || t.DataSize != b.DataSize:
+		case !t.UpdateTime.Before(base.CreatedAt.Add(-time.Second)):
+		default:
+			continue
+		}
+		changed = append(changed, t.Name)
+	}
+	return changed
+}
+
+// dump writes the archive of manifest. A nil tables dumps the whole
+// database, otherwise only the given tables are dumped.
+func (m MySqlBackup) dump(ctx context.Context, manifest *entity.Manifest, tables []string, suffix string) (string, error) {
+	createdAt := manifest.CreatedAt.Local()
+
+	// final archive file
+	filename := fmt.Sprintf("%s_%s%s%s", m.Database, createdAt.Format("20060102_150405"), suffix, m.Compression.Ext())
+	if m.Encryptor != nil {
+		filename += m.Encryptor.Scheme().Ext()
+	}
 	outputPath := filepath.Join(".", filename)
 
-	// create output tar.gz file
+	tmpFile, err := os.CreateTemp("", "mysqldump-*.sql")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// copy mysqldump output to tmp file, hashing it on the way
+	hash := sha256.New()
+	var size int64
+	if tables == nil || len(tables) > 0 {
+		size, err = m.runDump(ctx, manifest.DumpOptions, tables, io.MultiWriter(tmpFile, hash))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if m.RecordBinlog && size > 0 {
+		if manifest.Binlog, err = readBinlogCoordinates(tmpFile); err != nil {
+			return "", err
+		}
+	}
+
+	// rewind temp file
+	if _, err := tmpFile.Seek(0, 0); err != nil {
+		return "", err
+	}
+
+	sqlFileName := fmt.Sprintf("%s.sql", m.Database)
+	manifest.Entries = append(manifest.Entries, entity.ManifestEntry{
+		Name:   sqlFileName,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// create output archive file
 	outfile, err := os.Create(outputPath)
 	if err != nil {
 		return "", err
 	}
-	defer outfile.Close()
 
-	// gzip writer
-	gzw := gzip.NewWriter(outfile)
-	defer gzw.Close()
+	// the tar trailer, codec flush and last encrypted chunk are only written
+	// on Close, the writers are closed explicitly once the archive is complete
+	closers := []io.Closer{outfile}
+	defer func() {
+		if closers != nil {
+			closeAll(closers)
+			os.Remove(outputPath)
+		}
+	}()
+
+	// encryption writer, applied on top of the compressed stream
+	var w io.Writer = outfile
+	if m.Encryptor != nil {
+		ew, err := m.Encryptor.Encrypt(outfile)
+		if err != nil {
+			return "", err
+		}
+		closers = append(closers, ew)
+		w = ew
+	}
+
+	// compression writer
+	cw, err := archive.Compress(w, m.Compression, m.CompressionLevel)
+	if err != nil {
+		return "", err
+	}
+	closers = append(closers, cw)
 
 	// tar writer
-	tw := tar.NewWriter(gzw)
-	defer tw.Close()
+	tw := tar.NewWriter(cw)
+	closers = append(closers, tw)
 
-	// create a tar header for the .sql file inside archive
-	sqlFileName := fmt.Sprintf("%s.sql", m.Database)
-	header := &tar.Header{
+	// manifest goes first so it can be read without unpacking the dump
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    entity.ManifestName,
+		Mode:    0600,
+		Size:    int64(len(manifestJSON)),
+		ModTime: createdAt,
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return "", err
+	}
+
+	// write tar header of the .sql file inside archive
+	if err := tw.WriteHeader(&tar.Header{
 		Name:    sqlFileName,
 		Mode:    0600,
-		Size:    0, // we'll use a pipe so size is unknown
-		ModTime: time.Now(),
+		Size:    size,
+		ModTime: createdAt,
+	}); err != nil {
+		return "", err
 	}
-	// we will fill Size later via pipe, so skip direct WriteHeader+Size.
 
-	// build mysqldump args
-	args := []string{
-		"-h", m.Host,
-		"-P", m.Port,
-		"-u", m.User,
-		fmt.Sprintf("--password=%s", m.Password),
-		m.Database,
+	// copy file into tar
+	if _, err := tmpFile.WriteTo(tw); err != nil {
+		return "", err
 	}
 
+	err = closeAll(closers)
+	closers = nil
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("archive write failed: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+// closeAll closes the writers from the last one wrapped to the underlying
+// file and returns the first error.
+func closeAll(closers []io.Closer) error {
+	var first error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// runDump runs mysqldump into w, the temp file written by dump because tar
+// needs to know the entry size.
+func (m MySqlBackup) runDump(ctx context.Context, options, tables []string, w io.Writer) (int64, error) {
+	// build mysqldump args
+	args := append(m.connArgs(), options...)
+	args = append(args, m.Database)
+	args = append(args, tables...)
+
 	// prepare command
 	cmd := exec.CommandContext(ctx, "mysqldump", args...)
 
-	// pipe mysqldump stdout directly into tar entry
+	// pipe mysqldump stdout
 	pr, pw, err := os.Pipe()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 	defer pr.Close()
 
@@ -77,95 +279,203 @@ func (m MySqlBackup) Dump(ctx context.Context) (string, error) {
 	// start mysqldump
 	if err := cmd.Start(); err != nil {
 		pw.Close()
-		return "", fmt.Errorf("mysqldump start failed: %w", err)
+		return 0, fmt.Errorf("mysqldump start failed: %w", err)
 	}
 
 	// close write end after command finishes
+	waitErr := make(chan error, 1)
 	go func() {
-		cmd.Wait()
+		waitErr <- cmd.Wait()
 		pw.Close()
 	}()
 
-	// since tar requires knowing size, we stream by reading
-	// workaround: buffer mysqldump into tar without setting Size
-	// (using io.Copy + WriteHeader with zero size + special flag)
-	// Instead we can capture to temp buffer, but that uses memory.
-	// Simpler: copy to tar directly, but we need Size…
-	// Solution: use io.Pipe again to avoid full buffer.
+	size, err := io.Copy(w, pr)
+	if err != nil {
+		return 0, fmt.Errorf("write to temp failed: %w", err)
+	}
+	if err := <-waitErr; err != nil {
+		return 0, fmt.Errorf("mysqldump failed: %w", err)
+	}
 
-	tmpFile, err := os.CreateTemp("", "mysqldump-*.sql")
+	return size, nil
+}
+
+// newManifest describes the server and database about to be dumped.
+func (m MySqlBackup) newManifest(ctx context.Context, createdAt time.Time) (*entity.Manifest, error) {
+	createdAt = createdAt.Truncate(time.Second)
+
+	serverVersion, err := m.query(ctx, "SELECT VERSION()")
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to read server version: %w", err)
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	// copy mysqldump output to tmp file
-	if _, err := pr.WriteTo(tmpFile); err != nil {
-		return "", fmt.Errorf("write to temp failed: %w", err)
+	toolVersion, err := exec.CommandContext(ctx, "mysqldump", "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mysqldump version: %w", err)
 	}
 
-	// rewind temp file
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		return "", err
+	if m.RecordBinlog {
+		if err := m.checkBinlogFormat(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	// get size
-	info, _ := tmpFile.Stat()
-	header.Size = info.Size()
+	tables, err := m.tableStats(ctx, serverVersion)
+	if err != nil {
+		return nil, err
+	}
 
-	// write tar header
-	if err := tw.WriteHeader(header); err != nil {
-		return "", err
+	manifest := &entity.Manifest{
+		Version:          1,
+		Engine:           "mysql",
+		DBType:           int(MYSQL),
+		ServerVersion:    strings.TrimSpace(serverVersion),
+		ToolVersion:      version.Version,
+		DumpToolVersion:  strings.TrimSpace(string(toolVersion)),
+		Database:         m.Database,
+		CreatedAt:        createdAt.UTC(),
+		DumpOptions:      m.dumpOptions(string(toolVersion)),
+		Compression:      string(m.Compression),
+		CompressionLevel: m.CompressionLevel,
+		FreshStats:       true,
+		Tables:           tables,
+	}
+	if m.Encryptor != nil {
+		manifest.Encryption = string(m.Encryptor.Scheme())
 	}
 
-	// copy file into tar
-	if _, err := tmpFile.WriteTo(tw); err != nil {
-		return "", err
+	return manifest, nil
+}
+
+// tableStats reads table sizes and update times from information_schema,
+// and table checksums when enabled. MySQL 8 caches them for a day by
+// default, the cache is bypassed for the session.
+func (m MySqlBackup) tableStats(ctx context.Context, serverVersion string) ([]entity.ManifestTable, error) {
+	statement := fmt.Sprintf(
+		"SELECT TABLE_NAME, IFNULL(TABLE_ROWS, 0), IFNULL(DATA_LENGTH, 0), IFNULL(INDEX_LENGTH, 0), "+
+			"IFNULL(UNIX_TIMESTAMP(UPDATE_TIME), '') "+
+			"FROM information_schema.TABLES WHERE TABLE_SCHEMA = '%s' AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME",
+		strings.ReplaceAll(m.Database, "'", "''"),
+	)
+	if cachesTableStats(serverVersion) {
+		statement = "SET SESSION information_schema_stats_expiry = 0; " + statement
 	}
 
-	return outputPath, nil
+	out, err := m.query(ctx, statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table stats: %w", err)
+	}
+
+	tables := []entity.ManifestTable{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		t := entity.ManifestTable{Name: fields[0]}
+		t.Rows, _ = strconv.ParseInt(fields[1], 10, 64)
+		t.DataSize, _ = strconv.ParseInt(fields[2], 10, 64)
+		t.IndexSize, _ = strconv.ParseInt(fields[3], 10, 64)
+		if sec, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			updated := time.Unix(int64(sec), 0).UTC()
+			t.UpdateTime = &updated
+		}
+		tables = append(tables, t)
+	}
+
+	if m.ChecksumTables && len(tables) > 0 {
+		if err := m.checksumTables(ctx, tables); err != nil {
+			return nil, err
+		}
+	}
+
+	return tables, nil
+}
+
+// cachesTableStats reports whether the server caches the statistics of
+// information_schema.TABLES, MySQL does since 8.0, MariaDB doesn't.
+func cachesTableStats(serverVersion string) bool {
+	if strings.Contains(strings.ToLower(serverVersion), "mariadb") {
+		return false
+	}
+	major, _, _ := strings.Cut(serverVersion, ".")
+	n, err := strconv.Atoi(major)
+	return err == nil && n >= 8
+}
+
+// checksumTables fills the checksum of every table with CHECKSUM TABLE,
+// which reads every row.
+func (m MySqlBackup) checksumTables(ctx context.Context, tables []entity.ManifestTable) error {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = fmt.Sprintf("`%s`.`%s`", m.Database, t.Name)
+	}
+
+	out, err := m.query(ctx, "CHECKSUM TABLE "+strings.Join(names, ", "))
+	if err != nil {
+		return fmt.Errorf("failed to checksum tables: %w", err)
+	}
+
+	checksums := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		checksums[strings.TrimPrefix(fields[0], m.Database+".")] = fields[1]
+	}
+	for i := range tables {
+		tables[i].Checksum = checksums[tables[i].Name]
+	}
+
+	return nil
 }
 
 func (m MySqlBackup) Restore(ctx context.Context, reader io.ReadCloser) error {
 	defer reader.Close()
 
-	// Peek first few bytes to detect gzip
-	buf := make([]byte, 512)
-	n, err := reader.Read(buf)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read input: %w", err)
+	a, err := m.openArchive(reader)
+	if err != nil {
+		return err
 	}
-	peek := buf[:n]
-
-	// Create a reader that includes the peeked bytes
-	fullReader := io.MultiReader(bytes.NewReader(peek), reader)
+	defer a.close()
 
 	var sqlReader io.Reader
+	var expected *entity.ManifestEntry
 
-	if n >= 2 && peek[0] == 0x1f && peek[1] == 0x8b {
-		// gzip detected
-		gzr, err := gzip.NewReader(fullReader)
-		if err != nil {
-			return fmt.Errorf("failed to open gzip: %w", err)
-		}
-		defer gzr.Close()
-
-		tr := tar.NewReader(gzr)
+	if a.tar != nil {
+		var manifest *entity.Manifest
 		found := false
 
 		for {
-			hdr, err := tr.Next()
+			hdr, err := a.tar.Next()
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
 				return fmt.Errorf("failed to read tar: %w", err)
 			}
+			if hdr.Name == entity.ManifestName {
+				manifest, err = readManifest(a.tar)
+				if err != nil {
+					return err
+				}
+				if err := m.checkManifest(manifest); err != nil {
+					return err
+				}
+				fmt.Printf("Backup of %s taken at %s (MySQL %s)\n",
+					manifest.Database, manifest.CreatedAt.Local().Format(time.DateTime), manifest.ServerVersion)
+				continue
+			}
 			if filepath.Ext(hdr.Name) == ".sql" {
 				// found SQL file
-				sqlReader = tr
+				sqlReader = a.tar
 				found = true
+				if manifest != nil {
+					if e, ok := manifest.Entry(hdr.Name); ok {
+						expected = &e
+					}
+				}
 				break
 			}
 		}
@@ -174,18 +484,16 @@ func (m MySqlBackup) Restore(ctx context.Context, reader io.ReadCloser) error {
 			return fmt.Errorf("no .sql file found in tar archive")
 		}
 	} else {
-		// plain SQL file
-		sqlReader = fullReader
+		// plain SQL file, optionally compressed (eg: .sql.gz)
+		sqlReader = a.sql
 	}
 
+	// hash the SQL stream so it can be compared with the manifest
+	hash := sha256.New()
+	sqlReader = io.TeeReader(sqlReader, hash)
+
 	// prepare mysql restore command
-	args := []string{
-		"-h", m.Host,
-		"-P", m.Port,
-		"-u", m.User,
-		fmt.Sprintf("--password=%s", m.Password),
-		m.Database,
-	}
+	args := append(m.connArgs(), m.Database)
 
 	cmd := exec.CommandContext(ctx, "mysql", args...)
 	cmd.Stdin = sqlReader
@@ -196,9 +504,213 @@ func (m MySqlBackup) Restore(ctx context.Context, reader io.ReadCloser) error {
 		return fmt.Errorf("mysql restore failed: %w", err)
 	}
 
+	if expected != nil {
+		if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected.SHA256 {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", expected.Name, expected.SHA256, sum)
+		}
+	}
+
 	return nil
 }
 
+// ReadManifest reads the manifest of an archive, it stops reading as soon as
+// the manifest has been found. Archives written before manifests existed
+// return ErrNoManifest.
+func (m MySqlBackup) ReadManifest(_ context.Context, reader io.ReadCloser) (*entity.Manifest, error) {
+	defer reader.Close()
+
+	a, err := m.openArchive(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer a.close()
+
+	if a.tar == nil {
+		return nil, ErrNoManifest
+	}
+
+	for {
+		hdr, err := a.tar.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, ErrNoManifest
+			}
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+		if hdr.Name == entity.ManifestName {
+			return readManifest(a.tar)
+		}
+	}
+}
+
+// Verify reads the whole archive and checks its structure and the checksum of
+// every entry listed in the manifest, without touching the database.
+func (m MySqlBackup) Verify(_ context.Context, reader io.Reader) error {
+	a, err := m.openArchive(reader)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if a.tar == nil {
+		// plain SQL file, reading it to the end checks the compression stream
+		if _, err := io.Copy(io.Discard, a.sql); err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		return nil
+	}
+
+	var manifest *entity.Manifest
+	found := false
+
+	for {
+		hdr, err := a.tar.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		if hdr.Name == entity.ManifestName {
+			manifest, err = readManifest(a.tar)
+			if err != nil {
+				return err
+			}
+			if err := m.checkManifest(manifest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, a.tar)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		if filepath.Ext(hdr.Name) == ".sql" {
+			found = true
+		}
+
+		if manifest == nil {
+			continue
+		}
+		e, ok := manifest.Entry(hdr.Name)
+		if !ok {
+			return fmt.Errorf("%s is not listed in the manifest", hdr.Name)
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); size != e.Size || sum != e.SHA256 {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", e.Name, e.SHA256, sum)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("no .sql file found in tar archive")
+	}
+
+	return nil
+}
+
+// checkManifest refuses archives that were not taken from MySQL.
+func (m MySqlBackup) checkManifest(manifest *entity.Manifest) error {
+	if manifest.Engine != "mysql" || manifest.DBType != int(MYSQL) {
+		return fmt.Errorf("backup was taken from %s, it can't be restored into mysql", manifest.Engine)
+	}
+	return nil
+}
+
+func readManifest(r io.Reader) (*entity.Manifest, error) {
+	var manifest entity.Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// openedArchive is an archive with encryption and compression peeled off.
+type openedArchive struct {
+	tar   *tar.Reader // nil for plain SQL files
+	sql   io.Reader   // plain SQL stream when tar is nil
+	close func() error
+}
+
+// openArchive detects encryption, compression and the tar wrapper of r.
+func (m MySqlBackup) openArchive(r io.Reader) (*openedArchive, error) {
+	plain, err := m.decrypt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// detect compression from the magic bytes, plain files are passed through
+	dr, _, err := archive.Decompress(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	// tar archives carry "ustar" at offset 257, anything else is plain SQL
+	br := bufio.NewReaderSize(dr, 512)
+	peek, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		dr.Close()
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	if len(peek) >= 262 && string(peek[257:262]) == "ustar" {
+		return &openedArchive{tar: tar.NewReader(br), close: dr.Close}, nil
+	}
+
+	return &openedArchive{sql: br, close: dr.Close}, nil
+}
+
+// decrypt transparently decrypts r when it is an encrypted archive.
+func (m MySqlBackup) decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(encryption.PeekSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	scheme := encryption.Detect(peek)
+	if scheme == "" {
+		return br, nil
+	}
+
+	for _, d := range m.Decryptors {
+		if d.Scheme() == scheme {
+			return d.Decrypt(br)
+		}
+	}
+
+	return nil, fmt.Errorf("backup is encrypted (%s) but no decryption key is configured", scheme)
+}
+
+// connArgs returns the connection arguments shared by mysql and mysqldump.
+func (m MySqlBackup) connArgs() []string {
+	return []string{
+		"-h", m.Host,
+		"-P", m.Port,
+		"-u", m.User,
+		fmt.Sprintf("--password=%s", m.Password),
+	}
+}
+
+// query runs a single statement and returns its tab separated output
+// without column headers.
+func (m MySqlBackup) query(ctx context.Context, statement string) (string, error) {
+	args := append(m.connArgs(), "-N", "-B", "-e", statement)
+
+	cmd := exec.CommandContext(ctx, "mysql", args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(strings.TrimSpace(stderr.String() + " " + err.Error()))
+	}
+
+	return out.String(), nil
+}
+
 func (m MySqlBackup) DropAllTables(ctx context.Context) error {
 	// Step 1: get list of tables
 	args := []string{
@@ -221,6 +733,12 @@ func (m MySqlBackup) DropAllTables(ctx context.Context) error {
 
 	// Step 2: parse table names
 	tables := strings.Fields(out.String())
+
+	return m.DropTables(ctx, tables)
+}
+
+// DropTables drops the given tables ignoring foreign key constraints.
+func (m MySqlBackup) DropTables(ctx context.Context, tables []string) error {
 	if len(tables) == 0 {
 		return nil // nothing to drop
 	}
@@ -234,7 +752,7 @@ func (m MySqlBackup) DropAllTables(ctx context.Context) error {
 	dropSQL.WriteString("SET FOREIGN_KEY_CHECKS=1;\n") // re-enable FK checks
 
 	// Step 4: run DROP TABLE commands
-	args = []string{
+	args := []string{
 		"-h", m.Host,
 		"-P", m.Port,
 		"-u", m.User,
@@ -242,7 +760,7 @@ func (m MySqlBackup) DropAllTables(ctx context.Context) error {
 		m.Database,
 	}
 
-	cmd = exec.CommandContext(ctx, "mysql", args...)
+	cmd := exec.CommandContext(ctx, "mysql", args...)
 	cmd.Stdin = strings.NewReader(dropSQL.String())
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
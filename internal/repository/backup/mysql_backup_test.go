@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"ez-snapshot/internal/entity"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestChangedTables(t *testing.T) {
+	baseTime := time.Date(2026, 10, 18, 2, 0, 0, 0, time.UTC)
+	before := baseTime.Add(-time.Hour)
+	after := baseTime.Add(time.Hour)
+
+	table := func(updated *time.Time, rows, size int64, checksum string) entity.ManifestTable {
+		return entity.ManifestTable{Name: "t", UpdateTime: updated, Rows: rows, DataSize: size, Checksum: checksum}
+	}
+
+	tests := []struct {
+		name        string
+		base        entity.ManifestTable
+		current     entity.ManifestTable
+		cachedBase  bool // stats read from the information_schema cache
+		cachedStats bool
+		changed     bool
+	}{
+		{"unchanged", table(&before, 10, 16384, ""), table(&before, 10, 16384, ""), false, false, false},
+		// the cache may still hold the stats of the base after a change
+		{"equal cached stats", table(&before, 10, 16384, ""), table(&before, 10, 16384, ""), false, true, true},
+		{"equal stats, cached base", table(&before, 10, 16384, ""), table(&before, 10, 16384, ""), true, false, true},
+		{"update time unknown", table(&before, 10, 16384, ""), table(nil, 10, 16384, ""), false, false, true},
+		{"update time unknown in base", table(nil, 10, 16384, ""), table(&before, 10, 16384, ""), false, false, true},
+		{"updated", table(&before, 10, 16384, ""), table(&after, 10, 16384, ""), false, false, true},
+		{"rows differ", table(&before, 10, 16384, ""), table(&before, 11, 16384, ""), false, false, true},
+		{"size differs", table(&before, 10, 16384, ""), table(&before, 10, 32768, ""), false, false, true},
+		{"updated while the base was taken", table(&baseTime, 10, 16384, ""), table(&baseTime, 10, 16384, ""), false, false, true},
+		{"equal checksums", table(nil, 10, 16384, "42"), table(nil, 10, 16384, "42"), true, true, false},
+		{"checksums differ", table(&before, 10, 16384, "42"), table(&before, 10, 16384, "43"), false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &entity.Manifest{CreatedAt: baseTime, FreshStats: !tt.cachedBase, Tables: []entity.ManifestTable{tt.base}}
+			current := &entity.Manifest{CreatedAt: after, FreshStats: !tt.cachedStats, Tables: []entity.ManifestTable{tt.current}}
+
+			got := changedTables(base, current)
+			if changed := slices.Contains(got, "t"); changed != tt.changed {
+				t.Fatalf("changedTables = %v, want t changed: %v", got, tt.changed)
+			}
+		})
+	}
+
+	t.Run("new table", func(t *testing.T) {
+		base := &entity.Manifest{CreatedAt: baseTime, FreshStats: true}
+		current := &entity.Manifest{CreatedAt: after, FreshStats: true, Tables: []entity.ManifestTable{table(&before, 1, 16384, "")}}
+		if got := changedTables(base, current); !slices.Equal(got, []string{"t"}) {
+			t.Fatalf("changedTables = %v, want [t]", got)
+		}
+	})
+}
+
+func TestCachesTableStats(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"8.0.36", true},
+		{"8.4.2-log", true},
+		{"9.1.0", true},
+		{"8.0.mysql_aurora.3.04.0", true},
+		{"5.7.44-log", false},
+		{"10.11.6-MariaDB-0+deb12u1", false},
+		{"11.4.2-MariaDB", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := cachesTableStats(tt.version); got != tt.want {
+			t.Errorf("cachesTableStats(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
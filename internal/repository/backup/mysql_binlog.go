@@ -0,0 +1,317 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"ez-snapshot/internal/archive"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// eg : -- CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000042', SOURCE_LOG_POS=157;
+	binlogCoordinatesRe = regexp.MustCompile(`(?:MASTER|SOURCE)_LOG_FILE='([^']+)',\s*(?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+	// eg : SET @@GLOBAL.GTID_PURGED=/*!80000 '+'*/ '3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5';
+	gtidPurgedRe = regexp.MustCompile(`(?s)GTID_PURGED=(?:/\*!80000 '\+'\*/ )?'([^']*)'`)
+
+	dumpVersionRe = regexp.MustCompile(`(?:Distrib|Ver) (\d+)\.(\d+)\.(\d+)`)
+)
+
+// binlogHeaderSize is how much of the dump is searched for the binary log
+// coordinates, mysqldump writes them before any data.
+const binlogHeaderSize = 1 << 20
+
+// dumpOptions returns the mysqldump options recording the binary log
+// coordinates, --master-data was renamed --source-data in 8.0.26.
+func (m MySqlBackup) dumpOptions(dumpVersion string) []string {
+	if !m.RecordBinlog {
+		return []string{}
+	}
+
+	sourceData := "--source-data=2"
+	if strings.Contains(dumpVersion, "MariaDB") || versionBefore(dumpVersion, 8, 0, 26) {
+		sourceData = "--master-data=2"
+	}
+	return []string{"--single-transaction", sourceData}
+}
+
+func versionBefore(dumpVersion string, major, minor, patch int) bool {
+	matches := dumpVersionRe.FindAllStringSubmatch(dumpVersion, -1)
+	if len(matches) == 0 {
+		return false
+	}
+
+	// the server version follows "Distrib" on older clients, eg : Ver 10.13 Distrib 5.7.40
+	v := matches[len(matches)-1]
+	got := [3]int{}
+	for i := range got {
+		got[i], _ = strconv.Atoi(v[i+1])
+	}
+	want := [3]int{major, minor, patch}
+	for i := range got {
+		if got[i] != want[i] {
+			return got[i] < want[i]
+		}
+	}
+	return false
+}
+
+// readBinlogCoordinates finds the coordinates written by --source-data at
+// the head of a dump.
+func readBinlogCoordinates(f *os.File) (*entity.ManifestBinlog, error) {
+	head := make([]byte, binlogHeaderSize)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	match := binlogCoordinatesRe.FindSubmatch(head)
+	if match == nil {
+		return nil, fmt.Errorf("binary log coordinates not found in dump, is binary logging enabled on the server?")
+	}
+
+	binlog := &entity.ManifestBinlog{File: string(match[1])}
+	binlog.Position, _ = strconv.ParseInt(string(match[2]), 10, 64)
+	if gtid := gtidPurgedRe.FindSubmatch(head); gtid != nil {
+		binlog.GTIDSet = strings.Join(strings.Fields(string(gtid[1])), "")
+	}
+
+	return binlog, nil
+}
+
+func (m MySqlBackup) FirstBinlog(ctx context.Context) (string, error) {
+	out, err := m.query(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return "", fmt.Errorf("failed to list binary logs: %w", err)
+	}
+
+	first, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	name, _, _ := strings.Cut(first, "\t")
+	if name == "" {
+		return "", fmt.Errorf("binary logging is not enabled on the server")
+	}
+	return name, nil
+}
+
+// checkBinlogFormat makes sure the binary logs can be replayed for a single
+// database. Statements are filtered by their default database, which misses
+// cross database statements, row events by the database of their table.
+func (m MySqlBackup) checkBinlogFormat(ctx context.Context) error {
+	out, err := m.query(ctx, "SELECT @@GLOBAL.binlog_format")
+	if err != nil {
+		return fmt.Errorf("failed to read binlog_format: %w", err)
+	}
+	if format := strings.TrimSpace(out); !strings.EqualFold(format, "ROW") {
+		return fmt.Errorf("binlog_format is %s, point-in-time recovery needs binlog_format=ROW", format)
+	}
+	return nil
+}
+
+func (m MySqlBackup) StreamBinlogs(ctx context.Context, dir, from string) error {
+	if err := m.checkBinlogFormat(ctx); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	args := append(m.connArgs(),
+		"--read-from-remote-server",
+		"--raw",
+		"--stop-never",
+		// a trailing slash makes mysqlbinlog keep the original file names
+		"--result-file="+dir+string(filepath.Separator),
+		from,
+	)
+
+	cmd := exec.CommandContext(ctx, "mysqlbinlog", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("mysqlbinlog failed: %w", err)
+	}
+	return nil
+}
+
+// binlogMagic starts every binary log file, it is followed by events made of
+// a 19 byte header and their data.
+var binlogMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+const binlogEventHeaderSize = 19
+
+// LastBinlogEvent walks the event headers, the last event of a binary log
+// still being streamed may be incomplete and is left out.
+func (m MySqlBackup) LastBinlogEvent(_ context.Context, file string) (time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, binlogMagic) {
+		return time.Time{}, fmt.Errorf("%s is not a binary log", filepath.Base(file))
+	}
+
+	var last uint32
+	header := make([]byte, binlogEventHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return time.Time{}, err
+		}
+
+		// timestamp (4) | type (1) | server id (4) | event size (4) | ...
+		timestamp := binary.LittleEndian.Uint32(header[0:4])
+		size := binary.LittleEndian.Uint32(header[9:13])
+		if size < binlogEventHeaderSize {
+			return time.Time{}, fmt.Errorf("%s holds an invalid event", filepath.Base(file))
+		}
+		if _, err := br.Discard(int(size - binlogEventHeaderSize)); err == io.EOF {
+			break
+		} else if err != nil {
+			return time.Time{}, err
+		}
+
+		// the rotate event written when streaming starts has no timestamp
+		if timestamp != 0 {
+			last = timestamp
+		}
+	}
+
+	if last == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(int64(last), 0), nil
+}
+
+func (m MySqlBackup) PackBinlog(_ context.Context, file string) (string, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp("", "binlog-*")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if err := m.pack(out, in); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+func (m MySqlBackup) pack(out io.Writer, in io.Reader) error {
+	var w io.Writer = out
+	var ew io.WriteCloser
+	if m.Encryptor != nil {
+		var err error
+		if ew, err = m.Encryptor.Encrypt(out); err != nil {
+			return err
+		}
+		w = ew
+	}
+
+	cw, err := archive.Compress(w, m.Compression, m.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(cw, in); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if ew != nil {
+		return ew.Close()
+	}
+	return nil
+}
+
+func (m MySqlBackup) UnpackBinlog(_ context.Context, r io.Reader, dst string) error {
+	plain, err := m.decrypt(r)
+	if err != nil {
+		return err
+	}
+	dr, _, err := archive.Decompress(plain)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, dr); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func (m MySqlBackup) ReplayBinlogs(ctx context.Context, files []string, start *entity.ManifestBinlog, target RecoveryTarget) error {
+	// only replay the events of the restored database. The server already
+	// executed their GTIDs, they would be skipped if they were kept.
+	args := []string{
+		fmt.Sprintf("--start-position=%d", start.Position),
+		"--database=" + m.Database,
+		"--skip-gtids",
+	}
+	if target.GTIDSet != "" {
+		args = append(args, "--include-gtids="+target.GTIDSet)
+	} else {
+		// mysqlbinlog reads it in the local time zone
+		args = append(args, "--stop-datetime="+target.Time.Local().Format(time.DateTime))
+	}
+	args = append(args, files...)
+
+	decode := exec.CommandContext(ctx, "mysqlbinlog", args...)
+	decode.Stderr = os.Stderr
+	events, err := decode.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	apply := exec.CommandContext(ctx, "mysql", append(m.connArgs(), m.Database)...)
+	apply.Stdin = events
+	apply.Stdout = os.Stdout
+	apply.Stderr = os.Stderr
+
+	if err := decode.Start(); err != nil {
+		return fmt.Errorf("mysqlbinlog start failed: %w", err)
+	}
+	if err := apply.Run(); err != nil {
+		decode.Process.Kill()
+		decode.Wait()
+		return fmt.Errorf("binary log replay failed: %w", err)
+	}
+	if err := decode.Wait(); err != nil {
+		return fmt.Errorf("mysqlbinlog failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the Blob service REST API version requests are made with.
+const azureVersion = "2021-12-02"
+
+// azureMaxBlocks is the most blocks a block blob can be committed with.
+const azureMaxBlocks = 50000
+
+// azureImpl talks to the Azure Blob Storage REST API. Large backups are
+// staged as blocks and only become visible once the block list is committed.
+type azureImpl struct {
+	endpoint   *url.URL
+	account    string
+	container  string
+	prefix     string
+	accountKey []byte
+	sas        url.Values
+
+	accessTier string
+	blockSize  int64
+
+	client *http.Client
+	retry  retryPolicy // of every upload request
+}
+
+func newAzureImpl(cfg *config.AzureConfig) (*azureImpl, error) {
+	if cfg.Account == "" || cfg.Container == "" {
+		return nil, errors.New("azure.account and azure.container are required by the azure storage")
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.Account)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid azure.endpoint: %s", endpoint)
+	}
+
+	a := &azureImpl{
+		endpoint:   u,
+		account:    cfg.Account,
+		container:  cfg.Container,
+		accessTier: cfg.AccessTier,
+		blockSize:  max(cfg.BlockSize, 1<<20),
+		client:     &http.Client{},
+	}
+
+	switch {
+	case cfg.SASToken != "":
+		if a.sas, err = url.ParseQuery(strings.TrimPrefix(cfg.SASToken, "?")); err != nil {
+			return nil, fmt.Errorf("invalid azure.sas_token: %w", err)
+		}
+	case cfg.AccountKey != "":
+		if a.accountKey, err = base64.StdEncoding.DecodeString(cfg.AccountKey); err != nil {
+			return nil, fmt.Errorf("invalid azure.account_key: %w", err)
+		}
+	default:
+		return nil, errors.New("azure.account_key or azure.sas_token is required by the azure storage")
+	}
+
+	if prefix := strings.Trim(cfg.Prefix, "/"); prefix != "" {
+		a.prefix = prefix + "/"
+	}
+
+	return a, nil
+}
+
+// blobURL returns the URL of the blob key (relative to the prefix), or of the
+// container when key is empty.
+func (a *azureImpl) blobURL(key string, query url.Values) *url.URL {
+	u := *a.endpoint
+	p := "/" + a.container
+	if key != "" {
+		p += "/" + a.prefix + key
+	}
+	u.Path = a.endpoint.Path + p
+	u.RawPath = a.endpoint.EscapedPath() + escapePath(p)
+
+	q := url.Values{}
+	for k, v := range a.sas {
+		q[k] = v
+	}
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return &u
+}
+
+// do authorizes and sends a request, non 2xx responses are turned into
+// errors.
+func (a *azureImpl) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+	if a.accountKey != nil {
+		req.Header.Set("Authorization", "SharedKey "+a.account+":"+a.signature(req))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, azureError(method, resp)
+	}
+	return resp, nil
+}
+
+// signature computes the Shared Key signature of a request.
+func (a *azureImpl) signature(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + a.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		values := query[k]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	h := req.Header
+	stringToSign := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, a.accountKey)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *azureImpl) setRetry(p retryPolicy) {
+	a.retry = p
+}
+
+// send is do retried on its own, a failed block is staged again instead of
+// restarting the upload.
+func (a *azureImpl) send(ctx context.Context, op, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	err := a.retry.do(ctx, op, func() error {
+		var err error
+		resp, err = a.do(ctx, method, u, header, body)
+		return err
+	})
+	return resp, err
+}
+
+func azureError(method string, resp *http.Response) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		message, _, _ := strings.Cut(e.Message, "\n")
+		return withStatus(resp, fmt.Errorf("azure %s failed: %s: %s", method, e.Code, message))
+	}
+	if code := resp.Header.Get("X-Ms-Error-Code"); code != "" {
+		return withStatus(resp, fmt.Errorf("azure %s failed: %s", method, code))
+	}
+	return withStatus(resp, fmt.Errorf("azure %s failed: %s", method, resp.Status))
+}
+
+// blobHeaders are sent when a blob is created or committed.
+func (a *azureImpl) blobHeaders(meta Metadata) http.Header {
+	h := http.Header{}
+	h.Set("X-Ms-Blob-Content-Type", "application/octet-stream")
+	setMetadataHeaders(h, "X-Ms-Meta-", meta)
+	if a.accessTier != "" {
+		h.Set("X-Ms-Access-Tier", a.accessTier)
+	}
+	return h
+}
+
+// Upload sends blobs smaller than a block with a single Put Blob, larger ones
+// are staged block by block holding one block in memory at a time, then
+// committed with Put Block List.
+func (a *azureImpl) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	fmt.Printf("Begin upload to azure://%s/%s%s\n", a.container, a.prefix, key)
+
+	pc := &progressCounter{}
+	r = io.TeeReader(r, pc)
+
+	block := make([]byte, a.blockSize)
+	n, err := io.ReadFull(r, block)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	if n < len(block) {
+		h := a.blobHeaders(meta)
+		h.Set("X-Ms-Blob-Type", "BlockBlob")
+		resp, err := a.send(ctx, "azure upload", http.MethodPut, a.blobURL(key, nil), h, block[:n])
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	} else if err := a.stagedUpload(ctx, key, r, block, meta); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+// stagedUpload stages the blocks of r, the first one being already read.
+// Uncommitted blocks are garbage collected by the service.
+func (a *azureImpl) stagedUpload(ctx context.Context, key string, r io.Reader, block []byte, meta Metadata) error {
+	var ids []string
+	n := len(block)
+
+	for n > 0 {
+		if len(ids) == azureMaxBlocks {
+			return fmt.Errorf("backup exceeds %d blocks, increase azure.block_size", azureMaxBlocks)
+		}
+
+		// block ids must all have the same length
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", len(ids))))
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+		resp, err := a.send(ctx, fmt.Sprintf("azure upload of block %d", len(ids)+1), http.MethodPut, a.blobURL(key, query), nil, block[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		ids = append(ids, id)
+
+		var rerr error
+		n, rerr = io.ReadFull(r, block)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: ids})
+	if err != nil {
+		return err
+	}
+
+	h := a.blobHeaders(meta)
+	h.Set("Content-Type", "application/xml")
+	resp, err := a.send(ctx, "azure commit of block list", http.MethodPut, a.blobURL(key, url.Values{"comp": {"blocklist"}}), h, append([]byte(xml.Header), body...))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (a *azureImpl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, a.blobURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat reads the properties of the blob, its metadata included.
+func (a *azureImpl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	resp, err := a.do(ctx, http.MethodHead, a.blobURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &entity.Backup{
+		Path:     key,
+		Name:     key,
+		Size:     resp.ContentLength,
+		MimeType: resp.Header.Get("Content-Type"),
+		ModTime:  modTime,
+		Tier:     resp.Header.Get("X-Ms-Access-Tier"),
+		Metadata: metadataHeaders(resp.Header, "X-Ms-Meta-"),
+	}, nil
+}
+
+func (a *azureImpl) Delete(ctx context.Context, key string) error {
+	resp, err := a.do(ctx, http.MethodDelete, a.blobURL(key, nil), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List lists the blobs directly under the prefix.
+func (a *azureImpl) List(ctx context.Context) ([]*entity.Backup, error) {
+	backups := []*entity.Backup{}
+	marker := ""
+
+	for {
+		query := url.Values{
+			"restype":   {"container"},
+			"comp":      {"list"},
+			"prefix":    {a.prefix},
+			"delimiter": {"/"},
+			"include":   {"metadata"},
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := a.do(ctx, http.MethodGet, a.blobURL("", query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+					ContentType   string `xml:"Content-Type"`
+					AccessTier    string `xml:"AccessTier"`
+				} `xml:"Properties"`
+				Metadata struct {
+					Items []struct {
+						XMLName xml.Name
+						Value   string `xml:",chardata"`
+					} `xml:",any"`
+				} `xml:"Metadata"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, b := range result.Blobs {
+			name := strings.TrimPrefix(b.Name, a.prefix)
+			if name == "" {
+				continue
+			}
+			modTime, _ := http.ParseTime(b.Properties.LastModified)
+			var meta Metadata
+			for _, item := range b.Metadata.Items {
+				if meta == nil {
+					meta = Metadata{}
+				}
+				meta[strings.ToLower(item.XMLName.Local)] = item.Value
+			}
+			backups = append(backups, &entity.Backup{
+				Path:     name,
+				Name:     name,
+				Size:     b.Properties.ContentLength,
+				MimeType: b.Properties.ContentType,
+				ModTime:  modTime,
+				Tier:     b.Properties.AccessTier,
+				Metadata: meta,
+			})
+		}
+
+		if result.NextMarker == "" {
+			return backups, nil
+		}
+		marker = result.NextMarker
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/config"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// TestAzureEmulator runs against Azurite with its well-known account, eg :
+// docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+// AZURITE_BLOB_ENDPOINT overrides its address.
+func TestAzureEmulator(t *testing.T) {
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:10000/devstoreaccount1"
+	}
+	requireEmulator(t, endpoint)
+
+	a, err := newAzureImpl(&config.AzureConfig{
+		Endpoint:   endpoint,
+		Account:    "devstoreaccount1",
+		AccountKey: "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		Container:  "ez-snapshot-test",
+		Prefix:     testPrefix(),
+		BlockSize:  1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.do(context.Background(), http.MethodPut, a.blobURL("", url.Values{"restype": {"container"}}), nil, nil)
+	var se *statusError
+	switch {
+	case err == nil:
+		resp.Body.Close()
+	case !errors.As(err, &se) || se.code != http.StatusConflict:
+		t.Fatalf("can't create container: %v", err)
+	}
+
+	testRoundTrip(t, a, map[string]int{
+		"empty":      0,
+		"single put": 1000,
+		"staged":     2<<20 + 1000,
+	})
+}
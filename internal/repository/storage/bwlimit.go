@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimit caps the upload and download rate of the storages, it may
+// change with the time of the day like rclone's --bwlimit, eg :
+// "08:00,512k 12:00,10M 18:00,off" or "Mon-08:00,1M:off Sat-00:00,off".
+type BandwidthLimit struct {
+	entries []bwEntry // sorted by minute of the week
+
+	up   pacer
+	down pacer
+}
+
+type bwEntry struct {
+	minute   int   // of the week, from Sunday 00:00, -1 for a constant limit
+	up, down int64 // bytes per second, 0 is unlimited
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseBandwidthLimit parses a limit in the rclone --bwlimit syntax. Rates
+// are in KiB/s unless suffixed with B, K, M or G, and UP:DOWN limits each
+// direction. It returns nil when s is empty or off.
+func ParseBandwidthLimit(s string) (*BandwidthLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || (len(fields) == 1 && strings.EqualFold(fields[0], "off")) {
+		return nil, nil
+	}
+
+	if len(fields) == 1 && !strings.Contains(fields[0], ",") {
+		up, down, err := parseRates(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return &BandwidthLimit{entries: []bwEntry{{minute: -1, up: up, down: down}}}, nil
+	}
+
+	var entries []bwEntry
+	for _, f := range fields {
+		at, rates, ok := strings.Cut(f, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid bandwidth limit %q, expected [Day-]HH:MM,RATE", f)
+		}
+		up, down, err := parseRates(rates)
+		if err != nil {
+			return nil, err
+		}
+
+		days := []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+		if day, clock, ok := strings.Cut(at, "-"); ok {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q in bandwidth limit", day)
+			}
+			days, at = []time.Weekday{weekday}, clock
+		}
+
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q in bandwidth limit", at)
+		}
+		for _, d := range days {
+			entries = append(entries, bwEntry{minute: int(d)*24*60 + t.Hour()*60 + t.Minute(), up: up, down: down})
+		}
+	}
+
+	// entries at the same time keep their order, the last one applies
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].minute < entries[j].minute })
+	return &BandwidthLimit{entries: entries}, nil
+}
+
+// parseRates parses RATE or UP:DOWN.
+func parseRates(s string) (int64, int64, error) {
+	upRate, downRate, split := strings.Cut(s, ":")
+	up, err := parseRate(upRate)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !split {
+		return up, up, nil
+	}
+	down, err := parseRate(downRate)
+	if err != nil {
+		return 0, 0, err
+	}
+	return up, down, nil
+}
+
+// parseRate parses a rate in bytes per second, eg : 512k, 1.5M or off.
+func parseRate(s string) (int64, error) {
+	if strings.EqualFold(s, "off") || s == "" {
+		return 0, nil
+	}
+
+	unit := 1 << 10
+	number := s
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "b":
+		unit = 1
+	case "k":
+	case "m":
+		unit = 1 << 20
+	case "g":
+		unit = 1 << 30
+	default:
+		number += "k"
+	}
+	number = number[:len(number)-1]
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth rate %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// rates returns the upload and download limits at t, 0 being unlimited.
+func (l *BandwidthLimit) rates(t time.Time) (int64, int64) {
+	if l == nil {
+		return 0, 0
+	}
+
+	minute := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+
+	// before the first entry of the week the last one still applies
+	e := l.entries[len(l.entries)-1]
+	for _, entry := range l.entries {
+		if entry.minute > minute {
+			break
+		}
+		e = entry
+	}
+	return e.up, e.down
+}
+
+// rcloneRate returns the limit at t in the core/bwlimit syntax.
+func (l *BandwidthLimit) rcloneRate(t time.Time) string {
+	format := func(n int64) string {
+		if n == 0 {
+			return "off"
+		}
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	up, down := l.rates(t)
+	return format(up) + ":" + format(down)
+}
+
+// pacer spreads the bytes sent in one direction over time, it is shared by
+// every concurrent transfer.
+type pacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes fit under limit bytes per second.
+func (p *pacer) wait(ctx context.Context, n int, limit int64) error {
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / float64(limit) * float64(time.Second)))
+	delay := p.next.Sub(now)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limitedReader paces the reads of a transfer. Seek is forwarded, so a
+// retried upload can still rewind its file.
+type limitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	pacer *pacer
+	limit func() int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	limit := l.limit()
+	if limit <= 0 {
+		return l.r.Read(p)
+	}
+
+	// small reads keep the rate smooth, about 10 per second
+	if size := max(int(limit/10), 512); len(p) > size {
+		p = p[:size]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.pacer.wait(l.ctx, n, limit); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := l.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("reader is not seekable")
+	}
+	return s.Seek(offset, whence)
+}
+
+type limitedReadCloser struct {
+	*limitedReader
+	io.Closer
+}
+
+// throttledRepo limits the bandwidth of the uploads and downloads of a
+// storage.
+type throttledRepo struct {
+	inner Repository
+	limit *BandwidthLimit
+}
+
+func newThrottledRepo(inner Repository, limit *BandwidthLimit) Repository {
+	return &throttledRepo{inner: inner, limit: limit}
+}
+
+func (t *throttledRepo) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	return t.inner.Upload(ctx, key, &limitedReader{
+		ctx:   ctx,
+		r:     r,
+		pacer: &t.limit.up,
+		limit: func() int64 { up, _ := t.limit.rates(time.Now()); return up },
+	}, meta)
+}
+
+func (t *throttledRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := t.inner.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return limitedReadCloser{
+		limitedReader: &limitedReader{
+			ctx:   ctx,
+			r:     body,
+			pacer: &t.limit.down,
+			limit: func() int64 { _, down := t.limit.rates(time.Now()); return down },
+		},
+		Closer: body,
+	}, nil
+}
+
+func (t *throttledRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	return t.inner.Stat(ctx, key)
+}
+
+func (t *throttledRepo) Delete(ctx context.Context, key string) error {
+	return t.inner.Delete(ctx, key)
+}
+
+func (t *throttledRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	return t.inner.List(ctx)
+}
+
+// Copy copies server-side when the wrapped storage supports it, the data
+// doesn't go through ez-snapshot.
+func (t *throttledRepo) Copy(ctx context.Context, key string, dst Repository, dstKey string) error {
+	c, ok := t.inner.(Copier)
+	if !ok {
+		return ErrCopyNotSupported
+	}
+	return c.Copy(ctx, key, dst, dstKey)
+}
+
+// baseRepo returns the storage wrapped by the metadata, retry and bandwidth
+// decorators.
+func baseRepo(r Repository) Repository {
+	for {
+		switch w := r.(type) {
+		case *retryRepo:
+			r = w.inner
+		case *throttledRepo:
+			r = w.inner
+		case *metaSidecarRepo:
+			r = w.inner
+		default:
+			return r
+		}
+	}
+}
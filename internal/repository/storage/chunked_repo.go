@@ -0,0 +1,577 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ez-snapshot/internal/archive"
+	"ez-snapshot/internal/encryption"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// chunkPrefix is the name prefix of chunk objects, chunks are stored
+	// next to the indexes as chunk-<sha256 of the plain chunk>, or
+	// chunk-<hmac-sha256 of the plain chunk> when encryption is configured.
+	chunkPrefix = "chunk-"
+	// indexExt is appended to the backup name of its chunk index.
+	indexExt = ".chunks"
+	// gcGracePeriod protects chunks of backups still being uploaded, their
+	// index is only written once every chunk is stored.
+	gcGracePeriod = 24 * time.Hour
+
+	// uploadLockPrefix names the marker of every upload in progress, GC
+	// refuses to run while one exists.
+	uploadLockPrefix = "lock-upload-"
+	// gcLockName is held while GC runs, uploads wait for it to be removed.
+	gcLockName = "lock-gc"
+	// lockTimeout is the age after which the GC lock of a crashed run is
+	// ignored, a running GC refreshes it.
+	lockTimeout      = time.Hour
+	lockPollInterval = 10 * time.Second
+)
+
+// Versions of the chunk index, they tell how the chunks are named.
+const (
+	indexSHA256Names = 1
+	indexKeyedNames  = 2
+)
+
+// chunkIndex lists the chunks a backup is reassembled from.
+type chunkIndex struct {
+	Version int      `json:"version"`
+	Size    int64    `json:"size"`
+	Chunks  []string `json:"chunks"`
+	// KeyID identifies the key of keyed chunk names, chunks are only
+	// verified against their name with the same key.
+	KeyID    string   `json:"key_id,omitempty"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// GarbageCollector is implemented by layouts that can leave unreferenced
+// objects behind.
+type GarbageCollector interface {
+	// GC removes unreferenced objects and returns how many were removed.
+	GC(ctx context.Context) (int, error)
+}
+
+// chunkedRepo stores each backup as an index of content-defined chunks, a
+// chunk shared between backups is only uploaded once. Each chunk is
+// compressed and encrypted on its own.
+type chunkedRepo struct {
+	inner      Repository
+	codec      archive.Codec
+	level      int
+	encryptor  encryption.Encryptor
+	decryptors []encryption.Decryptor
+
+	// chunkKey names the chunks, nil without encryption
+	chunkKey []byte
+	keyID    string
+}
+
+func newChunkedRepo(inner Repository, o storageOpts) (Repository, error) {
+	c := &chunkedRepo{
+		inner:      inner,
+		codec:      o.codec,
+		level:      o.level,
+		encryptor:  o.encryptor,
+		decryptors: o.decryptors,
+	}
+
+	if keyer, ok := o.encryptor.(encryption.ChunkKeyer); ok {
+		key, err := keyer.ChunkKey()
+		if err != nil {
+			return nil, fmt.Errorf("chunk key derivation failed: %w", err)
+		}
+		c.chunkKey = key
+		id := c.newHash(indexKeyedNames, "")
+		id.Write([]byte("key id"))
+		c.keyID = hex.EncodeToString(id.Sum(nil)[:8])
+	}
+	return c, nil
+}
+
+// newHash returns the hash naming the chunks of an index, or nil when the
+// names can't be verified, eg : chunks named with another key.
+func (c *chunkedRepo) newHash(version int, keyID string) hash.Hash {
+	switch {
+	case version < indexKeyedNames:
+		return sha256.New()
+	case c.chunkKey != nil && (keyID == "" || keyID == c.keyID):
+		return hmac.New(sha256.New, c.chunkKey)
+	default:
+		return nil
+	}
+}
+
+// Upload keeps the metadata in the index, chunks are shared between backups.
+func (c *chunkedRepo) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	unlock, err := c.lockUpload(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	objects, err := c.inner.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, chunkPrefix) {
+			stored[o.Name] = true
+		}
+	}
+
+	index := chunkIndex{Version: indexSHA256Names, Metadata: meta}
+	if c.chunkKey != nil {
+		index.Version, index.KeyID = indexKeyedNames, c.keyID
+	}
+	reused := 0
+	ch := newChunker(r)
+	for {
+		chunk, err := ch.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		h := c.newHash(index.Version, index.KeyID)
+		h.Write(chunk)
+		name := chunkPrefix + hex.EncodeToString(h.Sum(nil))
+		index.Chunks = append(index.Chunks, name)
+		index.Size += int64(len(chunk))
+
+		if stored[name] {
+			reused++
+			continue
+		}
+
+		encoded, err := c.encode(chunk)
+		if err != nil {
+			return "", err
+		}
+		if _, err := c.inner.Upload(ctx, name, bytes.NewReader(encoded), nil); err != nil {
+			return "", fmt.Errorf("chunk upload failed: %w", err)
+		}
+		stored[name] = true
+	}
+
+	b, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.inner.Upload(ctx, key+indexExt, bytes.NewReader(b), nil); err != nil {
+		return "", fmt.Errorf("index upload failed: %w", err)
+	}
+
+	fmt.Printf("Deduplicated %d of %d chunk(s)\n", reused, len(index.Chunks))
+	return key, nil
+}
+
+// lockUpload registers the upload in progress, then waits for a running GC
+// to finish. Both sides register before looking for the other, so GC never
+// deletes a chunk an upload is reusing. The returned func unregisters it.
+func (c *chunkedRepo) lockUpload(ctx context.Context) (func(), error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	name := uploadLockPrefix + hex.EncodeToString(id)
+	if _, err := c.inner.Upload(ctx, name, strings.NewReader(time.Now().UTC().Format(time.RFC3339)), nil); err != nil {
+		return nil, fmt.Errorf("upload lock failed: %w", err)
+	}
+	unlock := func() {
+		if err := c.inner.Delete(context.WithoutCancel(ctx), name); err != nil {
+			fmt.Printf("⚠️ Failed to remove the upload lock %s: %v\n", name, err)
+		}
+	}
+
+	waiting := false
+	for {
+		lock, err := c.inner.Stat(ctx, gcLockName)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return unlock, nil
+		case err != nil:
+			unlock()
+			return nil, err
+		case time.Since(lock.ModTime) > lockTimeout:
+			fmt.Printf("⚠️ Ignoring the stale gc lock from %s\n", lock.ModTime.Local().Format(time.DateTime))
+			return unlock, nil
+		}
+
+		if !waiting {
+			fmt.Println("Waiting for the garbage collection to finish...")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// encode compresses then encrypts a chunk.
+func (c *chunkedRepo) encode(chunk []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.Writer = &buf
+	var ew io.WriteCloser
+	if c.encryptor != nil {
+		var err error
+		ew, err = c.encryptor.Encrypt(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = ew
+	}
+
+	cw, err := archive.Compress(w, c.codec, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cw.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decode reverses encode, the codec and encryption are detected from the
+// chunk itself.
+func (c *chunkedRepo) decode(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(encryption.PeekSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var plain io.Reader = br
+	if scheme := encryption.Detect(peek); scheme != "" {
+		var d encryption.Decryptor
+		for _, dec := range c.decryptors {
+			if dec.Scheme() == scheme {
+				d = dec
+			}
+		}
+		if d == nil {
+			return nil, fmt.Errorf("chunk is encrypted (%s) but no decryption key is configured", scheme)
+		}
+		if plain, err = d.Decrypt(br); err != nil {
+			return nil, err
+		}
+	}
+
+	dr, _, err := archive.Decompress(plain)
+	return dr, err
+}
+
+func (c *chunkedRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	paths, err := c.paths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath, ok := paths[key+indexExt]
+	if !ok {
+		// not chunked, eg : a backup taken before switching layouts
+		return c.inner.Download(ctx, key)
+	}
+
+	index, err := c.readIndex(ctx, indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{ctx: ctx, repo: c, paths: paths, chunks: index.Chunks, version: index.Version, keyID: index.KeyID}, nil
+}
+
+// Stat returns the backup with the size and metadata held by its index.
+func (c *chunkedRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	b, err := c.inner.Stat(ctx, key+indexExt)
+	if errors.Is(err, ErrNotFound) {
+		return c.inner.Stat(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := c.readIndex(ctx, b.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.Path = strings.TrimSuffix(b.Path, indexExt)
+	b.Name = strings.TrimSuffix(b.Name, indexExt)
+	b.Size = index.Size
+	b.Metadata = index.Metadata
+	return b, nil
+}
+
+func (c *chunkedRepo) readIndex(ctx context.Context, path string) (*chunkIndex, error) {
+	r, err := c.inner.Download(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var index chunkIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid chunk index %s: %w", path, err)
+	}
+	return &index, nil
+}
+
+// paths maps the path of every stored object by its path (for indexes) and
+// by its name (for chunks).
+func (c *chunkedRepo) paths(ctx context.Context) (map[string]string, error) {
+	objects, err := c.inner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string, len(objects))
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, chunkPrefix) {
+			paths[o.Name] = o.Path
+		} else {
+			paths[o.Path] = o.Path
+		}
+	}
+	return paths, nil
+}
+
+func (c *chunkedRepo) Delete(ctx context.Context, key string) error {
+	paths, err := c.paths(ctx)
+	if err != nil {
+		return err
+	}
+
+	// chunks are left for GC, they may be shared with other backups
+	if indexPath, ok := paths[key+indexExt]; ok {
+		return c.inner.Delete(ctx, indexPath)
+	}
+	return c.inner.Delete(ctx, key)
+}
+
+func (c *chunkedRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	objects, err := c.inner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*entity.Backup, 0, len(objects))
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, chunkPrefix) || strings.HasPrefix(o.Name, uploadLockPrefix) || o.Name == gcLockName {
+			continue
+		}
+		if strings.HasSuffix(o.Name, indexExt) {
+			o.Name = strings.TrimSuffix(o.Name, indexExt)
+			o.Path = strings.TrimSuffix(o.Path, indexExt)
+		}
+		backups = append(backups, o)
+	}
+	return backups, nil
+}
+
+// GC removes the chunks no index refers to anymore. It refuses to run while
+// a backup is being uploaded.
+func (c *chunkedRepo) GC(ctx context.Context) (int, error) {
+	locked := time.Now()
+	if err := c.lockGC(ctx); err != nil {
+		return 0, err
+	}
+	refresh := func() error {
+		if time.Since(locked) < lockTimeout/2 {
+			return nil
+		}
+		locked = time.Now()
+		return c.lockGC(ctx)
+	}
+	defer func() {
+		if err := c.inner.Delete(context.WithoutCancel(ctx), gcLockName); err != nil {
+			fmt.Printf("⚠️ Failed to remove the gc lock: %v\n", err)
+		}
+	}()
+
+	objects, err := c.inner.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, uploadLockPrefix) && time.Since(o.ModTime) < gcGracePeriod {
+			return 0, fmt.Errorf("a backup is being uploaded since %s, retry once it is done",
+				o.ModTime.Local().Format(time.DateTime))
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Name, indexExt) {
+			continue
+		}
+		if err := refresh(); err != nil {
+			return 0, err
+		}
+		index, err := c.readIndex(ctx, o.Path)
+		if err != nil {
+			return 0, err
+		}
+		for _, name := range index.Chunks {
+			referenced[name] = true
+		}
+	}
+
+	removed := 0
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Name, chunkPrefix) || referenced[o.Name] {
+			continue
+		}
+		if time.Since(o.ModTime) < gcGracePeriod {
+			continue
+		}
+		if err := refresh(); err != nil {
+			return removed, err
+		}
+		if err := c.inner.Delete(ctx, o.Path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	// markers left behind by crashed uploads
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, uploadLockPrefix) {
+			if err := c.inner.Delete(ctx, o.Path); err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// lockGC writes or refreshes the gc lock.
+func (c *chunkedRepo) lockGC(ctx context.Context) error {
+	if _, err := c.inner.Upload(ctx, gcLockName, strings.NewReader(time.Now().UTC().Format(time.RFC3339)), nil); err != nil {
+		return fmt.Errorf("gc lock failed: %w", err)
+	}
+	return nil
+}
+
+// chunkReader downloads and decodes the chunks of a backup one at a time.
+type chunkReader struct {
+	ctx     context.Context
+	repo    *chunkedRepo
+	paths   map[string]string
+	chunks  []string
+	version int
+	keyID   string
+	current io.ReadCloser
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			if err := cr.open(cr.chunks[0]); err != nil {
+				return 0, err
+			}
+			cr.chunks = cr.chunks[1:]
+		}
+
+		n, err := cr.current.Read(p)
+		if err == io.EOF {
+			cr.current.Close()
+			cr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (cr *chunkReader) open(name string) error {
+	p, ok := cr.paths[name]
+	if !ok {
+		return fmt.Errorf("missing chunk %s", name)
+	}
+
+	body, err := cr.repo.inner.Download(cr.ctx, p)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// chunks are small, reading them fully releases the connection early
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	dr, err := cr.repo.decode(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("invalid chunk %s: %w", name, err)
+	}
+	cr.current = dr
+	if h := cr.repo.newHash(cr.version, cr.keyID); h != nil {
+		cr.current = &verifiedChunk{ReadCloser: dr, name: name, hash: h}
+	}
+	return nil
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.current != nil {
+		return cr.current.Close()
+	}
+	return nil
+}
+
+// verifiedChunk checks the chunk content against the hash in its name once
+// it has been read to the end.
+type verifiedChunk struct {
+	io.ReadCloser
+	name string
+	hash hash.Hash
+}
+
+func (v *verifiedChunk) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if sum := hex.EncodeToString(v.hash.Sum(nil)); chunkPrefix+sum != v.name {
+			return n, fmt.Errorf("chunk %s is corrupted", v.name)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"io"
+)
+
+// Content-defined chunking with a gear rolling hash (FastCDC style). Cut
+// points only depend on the surrounding bytes, so an insert in a dump only
+// changes the chunks around it and the rest is deduplicated.
+const (
+	minChunkSize = 512 << 10
+	maxChunkSize = 4 << 20
+
+	// 20 bits gives an average chunk size of about 1 MiB past minChunkSize
+	chunkMask = uint64(1<<20-1) << 44
+)
+
+// gearTable must never change, it would move every cut point and defeat
+// deduplication against existing chunks.
+var gearTable = func() [256]uint64 {
+	var t [256]uint64
+	seed := uint64(0x6a09e667f3bcc909)
+	for i := range t {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+type chunker struct {
+	r     io.Reader
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, 2*maxChunkSize)}
+}
+
+// Next returns the next chunk, it is only valid until the following call.
+func (c *chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	n := c.end - c.start
+	if n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := n
+	if n > minChunkSize {
+		limit := min(n, maxChunkSize)
+		cut = limit
+		data := c.buf[c.start : c.start+limit]
+
+		var fp uint64
+		for i := minChunkSize; i < limit; i++ {
+			fp = (fp << 1) + gearTable[data[i]]
+			if fp&chunkMask == 0 {
+				cut = i + 1
+				break
+			}
+		}
+	}
+
+	chunk := c.buf[c.start : c.start+cut]
+	c.start += cut
+	return chunk, nil
+}
+
+// fill makes sure at least maxChunkSize bytes are buffered unless the input
+// is exhausted.
+func (c *chunker) fill() error {
+	if c.end-c.start >= maxChunkSize || c.eof {
+		return nil
+	}
+
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < maxChunkSize && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkSums splits data and returns the SHA-256 of every chunk, checking
+// their sizes and that they add up to data.
+func chunkSums(t *testing.T, r io.Reader, data []byte) [][32]byte {
+	t.Helper()
+
+	var sums [][32]byte
+	var joined []byte
+	c := newChunker(r)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last := len(joined)+len(chunk) == len(data)
+		if len(chunk) == 0 || len(chunk) > maxChunkSize || (!last && len(chunk) <= minChunkSize) {
+			t.Fatalf("chunk %d is %d bytes, want %d < size <= %d", len(sums), len(chunk), minChunkSize, maxChunkSize)
+		}
+		joined = append(joined, chunk...)
+		sums = append(sums, sha256.Sum256(chunk))
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Fatalf("chunks join into %d bytes that differ from the %d chunked", len(joined), len(data))
+	}
+	return sums
+}
+
+func randomData(seed int64, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestChunkerSizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		chunks int // -1 when it isn't fixed
+	}{
+		{"empty", nil, 0},
+		{"one byte", []byte{1}, 1},
+		{"min chunk size", randomData(1, minChunkSize), 1},
+		{"max chunk size of zeros", make([]byte, maxChunkSize), 1},
+		// nothing to cut on, every chunk is forced at the max size
+		{"zeros", make([]byte, 3*maxChunkSize+1), 4},
+		{"random", randomData(2, 32<<20), -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sums := chunkSums(t, bytes.NewReader(tt.data), tt.data)
+			if tt.chunks >= 0 && len(sums) != tt.chunks {
+				t.Fatalf("got %d chunks, want %d", len(sums), tt.chunks)
+			}
+			if tt.chunks < 0 && len(sums) < 2 {
+				t.Fatalf("%d bytes of random data gave %d chunk", len(tt.data), len(sums))
+			}
+
+			// cut points don't depend on how the input is read
+			if half := chunkSums(t, iotest.HalfReader(bytes.NewReader(tt.data)), tt.data); !slices.Equal(half, sums) {
+				t.Fatal("reading in small pieces gave other chunks")
+			}
+		})
+	}
+}
+
+func TestChunkerBoundaryStability(t *testing.T) {
+	data := randomData(3, 32<<20)
+	const at = 13 << 20
+
+	tests := []struct {
+		name   string
+		edited []byte
+	}{
+		{"insert", append(append(append([]byte{}, data[:at]...), randomData(4, 100)...), data[at:]...)},
+		{"delete", append(append([]byte{}, data[:at]...), data[at+100:]...)},
+		{"overwrite", append(append(append([]byte{}, data[:at]...), randomData(5, 100)...), data[at+100:]...)},
+		{"prepend", append(randomData(6, 100), data...)},
+	}
+
+	original := map[[32]byte]bool{}
+	for _, sum := range chunkSums(t, bytes.NewReader(data), data) {
+		original[sum] = true
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := 0
+			for _, sum := range chunkSums(t, bytes.NewReader(tt.edited), tt.edited) {
+				if !original[sum] {
+					changed++
+				}
+			}
+			// the chunk holding the edit, and the next one when the edit
+			// moved a cut point
+			if changed > 2 {
+				t.Fatalf("%d chunks changed out of %d, want at most 2", changed, len(original))
+			}
+		})
+	}
+}
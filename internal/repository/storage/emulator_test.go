@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// requireEmulator skips the test when nothing listens on the host of
+// endpoint.
+func requireEmulator(t *testing.T, endpoint string) {
+	t.Helper()
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		t.Fatalf("invalid emulator endpoint: %s", endpoint)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("emulator unavailable at %s: %v", u.Host, err)
+	}
+	conn.Close()
+}
+
+// testPrefix returns an object name prefix unique to the test run, so runs
+// sharing an emulator don't see each other's objects.
+func testPrefix() string {
+	return fmt.Sprintf("ez-snapshot-test-%d/", time.Now().UnixNano())
+}
+
+// testRoundTrip uploads backups of the given sizes with metadata, then
+// lists, stats, downloads and deletes them.
+func testRoundTrip(t *testing.T, repo Repository, sizes map[string]int) {
+	ctx := context.Background()
+
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			key := fmt.Sprintf("db-%d.sql.zst", size)
+			content := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(content)
+			meta := Metadata{"database": "db", "engine": "mysql"}
+
+			if _, err := repo.Upload(ctx, key, bytes.NewReader(content), meta); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			t.Cleanup(func() { repo.Delete(ctx, key) })
+
+			backups, err := repo.List(ctx)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			found := false
+			for _, b := range backups {
+				if b.Path == key {
+					found = b.Size == int64(size)
+				}
+			}
+			if !found {
+				t.Fatalf("List doesn't return %s of %d bytes", key, size)
+			}
+
+			b, err := repo.Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if b.Path != key || b.Size != int64(size) || b.ModTime.IsZero() {
+				t.Fatalf("Stat = %+v", b)
+			}
+			for k, v := range meta {
+				if b.Metadata[k] != v {
+					t.Fatalf("Stat metadata %s = %q, want %q", k, b.Metadata[k], v)
+				}
+			}
+
+			rc, err := repo.Download(ctx, key)
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("Download returned %d bytes that differ from the %d uploaded", len(got), size)
+			}
+
+			if err := repo.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := repo.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
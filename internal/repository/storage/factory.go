@@ -2,9 +2,102 @@ package storage
 
 import (
 	"context"
+	"ez-snapshot/internal/archive"
 	"ez-snapshot/internal/config"
+	"fmt"
 )
 
-func New(_ context.Context, cfg *config.RCloneConfig) Repository {
-	return newRCloneImpl(cfg.Host, cfg.Fs, cfg.Remote)
+func New(_ context.Context, cfg *config.StorageConfig, opts ...Opts) (Repository, error) {
+	o := storageOpts{
+		codec: archive.Zstd,
+	}
+
+	// apply all user-provided options
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	if len(cfg.Targets) == 0 {
+		return newRepo(cfg, o)
+	}
+
+	replicas := make([]replica, 0, len(cfg.Targets))
+	for _, target := range cfg.Targets {
+		repo, err := newRepo(target, o)
+		if err != nil {
+			return nil, fmt.Errorf("storage target %s: %w", target.Name, err)
+		}
+		replicas = append(replicas, replica{name: target.Name, repo: repo})
+	}
+	return newReplicatedRepo(replicas, cfg.MinReplicas), nil
+}
+
+// newRepo returns the repository of a single storage.
+func newRepo(cfg *config.StorageConfig, o storageOpts) (Repository, error) {
+	layout, err := ParseLayout(cfg.Layout)
+	if err != nil {
+		return nil, err
+	}
+
+	storageType, err := ParseStorageType(cfg.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	var repo Repository
+	switch storageType {
+	case Local:
+		if repo, err = newLocalImpl(cfg.Local); err != nil {
+			return nil, err
+		}
+	case AwsS3:
+		if repo, err = newS3Impl(cfg.S3); err != nil {
+			return nil, err
+		}
+	case Gcs:
+		if repo, err = newGCSImpl(cfg.GCS); err != nil {
+			return nil, err
+		}
+	case Sftp:
+		if repo, err = newSFTPImpl(cfg.SFTP); err != nil {
+			return nil, err
+		}
+	case Azure:
+		if repo, err = newAzureImpl(cfg.Azure); err != nil {
+			return nil, err
+		}
+	case WebDAV:
+		if repo, err = newWebDAVImpl(cfg.WebDAV); err != nil {
+			return nil, err
+		}
+	default:
+		if repo, err = newRCloneImpl(cfg.Rclone, o.bwlimit); err != nil {
+			return nil, err
+		}
+	}
+
+	switch storageType {
+	case Local, Sftp, WebDAV, Rclone:
+		// files have no metadata of their own
+		repo = newMetaSidecarRepo(repo)
+	}
+
+	if o.retry.attempts > 1 {
+		repo = newRetryRepo(repo, o.retry)
+	}
+	if o.bwlimit != nil {
+		repo = newThrottledRepo(repo, o.bwlimit)
+	}
+
+	switch {
+	case layout == Chunked:
+		// chunks are small, they never need to be split
+		if repo, err = newChunkedRepo(repo, o); err != nil {
+			return nil, err
+		}
+	case cfg.MaxVolumeSize > 0:
+		repo = newVolumeRepo(repo, cfg.MaxVolumeSize)
+	}
+
+	return repo, nil
 }
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsScope         = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// tokenSource returns OAuth2 access tokens, an empty token sends requests
+// without authorization.
+type tokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+type noToken struct{}
+
+func (noToken) Token(context.Context) (string, error) {
+	return "", nil
+}
+
+// cachedToken refreshes the token shortly before it expires.
+type cachedToken struct {
+	mu      sync.Mutex
+	token   string
+	expiry  time.Time
+	refresh func(ctx context.Context) (string, time.Duration, error)
+}
+
+func (c *cachedToken) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expiry) > time.Minute {
+		return c.token, nil
+	}
+
+	token, ttl, err := c.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiry = token, time.Now().Add(ttl)
+	return c.token, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func fetchToken(client *http.Client, req *http.Request) (string, time.Duration, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return "", 0, fmt.Errorf("token request failed: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var t tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, err
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
+
+// newServiceAccountToken exchanges a JWT signed with the service account key
+// for an access token.
+func newServiceAccountToken(path string, client *http.Client) (tokenSource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gcs credentials: %w", err)
+	}
+
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("invalid gcs credentials %s: %w", path, err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("gcs credentials %s is not a service account key", path)
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("gcs credentials %s has no private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gcs private key: %w", err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs private key is not an RSA key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	return &cachedToken{refresh: func(ctx context.Context) (string, time.Duration, error) {
+		now := time.Now()
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+		claims, err := json.Marshal(map[string]any{
+			"iss":   key.ClientEmail,
+			"scope": gcsScope,
+			"aud":   key.TokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			return "", 0, err
+		}
+
+		unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+		hash := sha256.Sum256([]byte(unsigned))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+		if err != nil {
+			return "", 0, err
+		}
+
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return fetchToken(client, req)
+	}}, nil
+}
+
+// newMetadataToken uses the service account attached to the GCE instance or
+// GKE workload.
+func newMetadataToken(client *http.Client) tokenSource {
+	return &cachedToken{refresh: func(ctx context.Context) (string, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataToken, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return fetchToken(client, req)
+	}}
+}
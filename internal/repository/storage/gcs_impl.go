@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcsChunkAlign is the granularity of resumable upload chunks.
+const gcsChunkAlign = 256 << 10
+
+// gcsImpl talks to the Google Cloud Storage JSON API.
+type gcsImpl struct {
+	endpoint     string
+	bucket       string
+	prefix       string
+	storageClass string
+	chunkSize    int64
+	tokens       tokenSource
+	client       *http.Client
+	retry        retryPolicy // of every upload chunk
+}
+
+func newGCSImpl(cfg *config.GCSConfig) (*gcsImpl, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("gcs.bucket is required by the gcs storage")
+	}
+
+	client := &http.Client{}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+
+	var tokens tokenSource
+	switch {
+	case cfg.CredentialsFile != "":
+		var err error
+		if tokens, err = newServiceAccountToken(cfg.CredentialsFile, client); err != nil {
+			return nil, err
+		}
+	case endpoint != "https://storage.googleapis.com":
+		// eg : fake-gcs-server
+		tokens = noToken{}
+	default:
+		tokens = newMetadataToken(client)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	// chunks must be a multiple of 256 KiB
+	chunkSize := max(cfg.ChunkSize/gcsChunkAlign, 1) * gcsChunkAlign
+
+	return &gcsImpl{
+		endpoint:     endpoint,
+		bucket:       cfg.Bucket,
+		prefix:       prefix,
+		storageClass: cfg.StorageClass,
+		chunkSize:    chunkSize,
+		tokens:       tokens,
+		client:       client,
+	}, nil
+}
+
+func (g *gcsImpl) objectURL(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(g.prefix+key))
+}
+
+// do sends an authorized request. Responses with an unexpected status are
+// turned into errors.
+func (g *gcsImpl) do(req *http.Request, expected ...int) (*http.Response, error) {
+	token, err := g.tokens.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := resp.StatusCode/100 == 2
+	for _, code := range expected {
+		ok = ok || resp.StatusCode == code
+	}
+	if !ok {
+		defer resp.Body.Close()
+		return nil, gcsError(req.Method, resp)
+	}
+	return resp, nil
+}
+
+func gcsError(method string, resp *http.Response) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+		return withStatus(resp, fmt.Errorf("gcs %s failed: %s: %s", method, resp.Status, e.Error.Message))
+	}
+	return withStatus(resp, fmt.Errorf("gcs %s failed: %s", method, resp.Status))
+}
+
+// Upload streams the object with a resumable upload, one chunk is held in
+// memory at a time.
+func (g *gcsImpl) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	fmt.Printf("Begin upload to gs://%s/%s%s\n", g.bucket, g.prefix, key)
+
+	session, err := g.startUpload(ctx, key, meta)
+	if err != nil {
+		return "", err
+	}
+
+	pc := &progressCounter{}
+	r = io.TeeReader(r, pc)
+
+	chunk := make([]byte, g.chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+
+		// a short chunk is the last one, a full one may be followed by more
+		total := int64(-1)
+		if n < len(chunk) {
+			total = offset + int64(n)
+		}
+		if err := g.resumeChunk(ctx, session, chunk[:n], offset, total); err != nil {
+			return "", err
+		}
+		offset += int64(n)
+
+		if total >= 0 {
+			break
+		}
+	}
+
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+func (g *gcsImpl) startUpload(ctx context.Context, key string, meta Metadata) (string, error) {
+	object := map[string]any{"name": g.prefix + key}
+	if g.storageClass != "" {
+		object["storageClass"] = g.storageClass
+	}
+	if len(meta) > 0 {
+		object["metadata"] = meta
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable", g.endpoint, url.PathEscape(g.bucket))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+
+	resp, err := g.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("gcs resumable upload has no session")
+	}
+	return session, nil
+}
+
+func (g *gcsImpl) setRetry(p retryPolicy) {
+	g.retry = p
+}
+
+// resumeChunk uploads a chunk, a failed attempt resumes from the bytes the
+// session persisted.
+func (g *gcsImpl) resumeChunk(ctx context.Context, session string, chunk []byte, offset, total int64) error {
+	retried := false
+	return g.retry.do(ctx, fmt.Sprintf("gcs upload at offset %d", offset), func() error {
+		if retried {
+			persisted, done, err := g.uploadStatus(ctx, session)
+			if err != nil {
+				return err
+			}
+			end := offset + int64(len(chunk))
+			if done || (persisted == end && total < 0) {
+				return nil
+			}
+			if persisted > offset && persisted < end {
+				chunk = chunk[persisted-offset:]
+				offset = persisted
+			}
+		}
+		retried = true
+		return g.uploadChunk(ctx, session, chunk, offset, total)
+	})
+}
+
+// uploadStatus returns how many bytes of a resumable upload are persisted,
+// and whether it is complete.
+func (g *gcsImpl) uploadStatus(ctx context.Context, session string) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+
+	resp, err := g.do(req, http.StatusPermanentRedirect)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		return 0, true, nil
+	}
+	return persistedBytes(resp.Header.Get("Range")), false, nil
+}
+
+// uploadChunk sends chunk at offset, total is -1 until the size is known.
+// Bytes the server didn't persist are sent again.
+func (g *gcsImpl) uploadChunk(ctx context.Context, session string, chunk []byte, offset, total int64) error {
+	for {
+		size := "*"
+		if total >= 0 {
+			size = strconv.FormatInt(total, 10)
+		}
+		contentRange := fmt.Sprintf("bytes */%s", size)
+		if len(chunk) > 0 {
+			contentRange = fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk))-1, size)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Range", contentRange)
+
+		// 308 : the chunk was received, the upload is not complete
+		resp, err := g.do(req, http.StatusPermanentRedirect)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPermanentRedirect {
+			return nil
+		}
+		if total >= 0 && len(chunk) == 0 {
+			return errors.New("gcs upload was not finalized")
+		}
+
+		persisted := persistedBytes(resp.Header.Get("Range"))
+		if persisted >= offset+int64(len(chunk)) {
+			if total >= 0 {
+				// the final chunk must complete the upload
+				return errors.New("gcs upload was not finalized")
+			}
+			return nil
+		}
+		if persisted < offset {
+			return fmt.Errorf("gcs upload lost data: %d bytes persisted, %d expected", persisted, offset)
+		}
+		chunk = chunk[persisted-offset:]
+		offset = persisted
+	}
+}
+
+// persistedBytes parses the Range header of a 308 response, eg : bytes=0-1023.
+func persistedBytes(header string) int64 {
+	_, end, ok := strings.Cut(header, "-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
+
+func (g *gcsImpl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// gcsObject holds the object fields read by Stat and List.
+type gcsObject struct {
+	Name         string    `json:"name"`
+	Size         string    `json:"size"`
+	Updated      time.Time `json:"updated"`
+	StorageClass string    `json:"storageClass"`
+	ContentType  string    `json:"contentType"`
+	Metadata     Metadata  `json:"metadata"`
+}
+
+const gcsObjectFields = "name,size,updated,storageClass,contentType,metadata"
+
+func (o *gcsObject) backup(name string) *entity.Backup {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return &entity.Backup{
+		Path:     name,
+		Name:     name,
+		Size:     size,
+		MimeType: o.ContentType,
+		ModTime:  o.Updated,
+		Tier:     o.StorageClass,
+		Metadata: o.Metadata,
+	}
+}
+
+func (g *gcsImpl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(key)+"?fields="+gcsObjectFields, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	defer resp.Body.Close()
+
+	var o gcsObject
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return nil, fmt.Errorf("invalid object response: %w", err)
+	}
+	return o.backup(key), nil
+}
+
+func (g *gcsImpl) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List lists the objects directly under the prefix.
+func (g *gcsImpl) List(ctx context.Context) ([]*entity.Backup, error) {
+	backups := []*entity.Backup{}
+	pageToken := ""
+
+	for {
+		query := url.Values{
+			"prefix":    {g.prefix},
+			"delimiter": {"/"},
+			"fields":    {"items(" + gcsObjectFields + "),nextPageToken"},
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		u := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), query.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := g.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, item := range result.Items {
+			name := strings.TrimPrefix(item.Name, g.prefix)
+			if name == "" {
+				continue
+			}
+			backups = append(backups, item.backup(name))
+		}
+
+		if result.NextPageToken == "" {
+			return backups, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"bytes"
+	"ez-snapshot/internal/config"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestGCSEmulator runs against fake-gcs-server, eg :
+// docker run -p 4443:4443 fsouza/fake-gcs-server -scheme http
+// STORAGE_EMULATOR_HOST overrides its address.
+func TestGCSEmulator(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_EMULATOR_HOST")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:4443"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	requireEmulator(t, endpoint)
+
+	const bucket = "ez-snapshot-test"
+	resp, err := http.Post(endpoint+"/storage/v1/b?project=test", "application/json",
+		bytes.NewReader([]byte(`{"name":"`+bucket+`"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusConflict {
+		t.Fatalf("can't create bucket %s: %s", bucket, resp.Status)
+	}
+
+	g, err := newGCSImpl(&config.GCSConfig{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		Prefix:    testPrefix(),
+		ChunkSize: gcsChunkAlign,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, g, map[string]int{
+		"empty":        0,
+		"single chunk": 1000,
+		"resumable":    2*gcsChunkAlign + 1000,
+	})
+}
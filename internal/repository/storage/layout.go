@@ -0,0 +1,24 @@
+package storage
+
+import "fmt"
+
+// Layout is how backups are laid out on the remote.
+type Layout string
+
+const (
+	// Plain stores every backup as a single object.
+	Plain Layout = "plain"
+	// Chunked splits backups into deduplicated content-defined chunks.
+	Chunked Layout = "chunked"
+)
+
+func ParseLayout(s string) (Layout, error) {
+	switch l := Layout(s); l {
+	case "":
+		return Plain, nil
+	case Plain, Chunked:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unsupported storage layout: %s", s)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localImpl stores backups as files of a local directory.
+type localImpl struct {
+	dir string
+}
+
+func newLocalImpl(cfg *config.LocalConfig) (*localImpl, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("local.path is required by the local storage")
+	}
+	return &localImpl{dir: cfg.Path}, nil
+}
+
+func (l *localImpl) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	return filepath.Join(l.dir, key), nil
+}
+
+// Upload writes into a hidden temp file renamed once complete, so a partial
+// upload is never listed. Files have no metadata, it is kept in a sidecar.
+func (l *localImpl) Upload(_ context.Context, key string, r io.Reader, _ Metadata) (string, error) {
+	dst, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Begin upload to %s\n", dst)
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	pc := &progressCounter{}
+	if _, err := io.Copy(tmp, io.TeeReader(r, pc)); err != nil {
+		return "", err
+	}
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+func (l *localImpl) Download(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *localImpl) Stat(_ context.Context, key string) (*entity.Backup, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entity.Backup{
+		Path:    key,
+		Name:    filepath.Base(key),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}, nil
+}
+
+func (l *localImpl) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (l *localImpl) List(_ context.Context) ([]*entity.Backup, error) {
+	entries, err := os.ReadDir(l.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		// created on the first upload
+		return []*entity.Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*entity.Backup, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &entity.Backup{
+			Path:    e.Name(),
+			Name:    e.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return backups, nil
+}
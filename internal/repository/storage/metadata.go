@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// metaExt is appended to the object name of its metadata sidecar, for
+// storages without object metadata.
+const metaExt = ".meta"
+
+// setMetadataHeaders sends meta as headers, eg : X-Amz-Meta-<key>.
+func setMetadataHeaders(h http.Header, prefix string, meta Metadata) {
+	for k, v := range meta {
+		h.Set(prefix+k, v)
+	}
+}
+
+// metadataHeaders returns the metadata sent back as prefix headers, servers
+// may change the case of the keys.
+func metadataHeaders(h http.Header, prefix string) Metadata {
+	var meta Metadata
+	for k, v := range h {
+		if len(k) <= len(prefix) || !strings.EqualFold(k[:len(prefix)], prefix) {
+			continue
+		}
+		if meta == nil {
+			meta = Metadata{}
+		}
+		meta[strings.ToLower(k[len(prefix):])] = strings.Join(v, ",")
+	}
+	return meta
+}
+
+// notFound turns a 404 response into ErrNotFound.
+func notFound(err error, key string) error {
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return err
+}
+
+// metaSidecarRepo keeps the metadata of an object in a <name>.meta JSON
+// sidecar next to it, for storages that can't attach metadata to files.
+type metaSidecarRepo struct {
+	inner Repository
+}
+
+func newMetaSidecarRepo(inner Repository) Repository {
+	return &metaSidecarRepo{inner: inner}
+}
+
+// Upload writes the sidecar once the object is stored, an object is never
+// listed with metadata it doesn't have.
+func (m *metaSidecarRepo) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	out, err := m.inner.Upload(ctx, key, r, nil)
+	if err != nil || len(meta) == 0 {
+		return out, err
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.inner.Upload(ctx, key+metaExt, bytes.NewReader(b), nil); err != nil {
+		return "", fmt.Errorf("metadata upload failed: %w", err)
+	}
+	return out, nil
+}
+
+func (m *metaSidecarRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	return m.inner.Download(ctx, key)
+}
+
+func (m *metaSidecarRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	b, err := m.inner.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := m.hasSidecar(ctx, key)
+	if err != nil || !ok {
+		return b, err
+	}
+
+	r, err := m.inner.Download(ctx, key+metaExt)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(io.LimitReader(r, 64<<10)).Decode(&b.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata of %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func (m *metaSidecarRepo) hasSidecar(ctx context.Context, key string) (bool, error) {
+	_, err := m.inner.Stat(ctx, key+metaExt)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *metaSidecarRepo) Delete(ctx context.Context, key string) error {
+	if err := m.inner.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	ok, err := m.hasSidecar(ctx, key)
+	if err != nil || !ok {
+		return err
+	}
+	return m.inner.Delete(ctx, key+metaExt)
+}
+
+// List hides the sidecars.
+func (m *metaSidecarRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	objects, err := m.inner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*entity.Backup, 0, len(objects))
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Name, metaExt) {
+			backups = append(backups, o)
+		}
+	}
+	return backups, nil
+}
+
+// Copy copies server-side when the wrapped storage supports it, the sidecar
+// follows its object.
+func (m *metaSidecarRepo) Copy(ctx context.Context, key string, dst Repository, dstKey string) error {
+	c, ok := m.inner.(Copier)
+	if !ok {
+		return ErrCopyNotSupported
+	}
+	if err := c.Copy(ctx, key, dst, dstKey); err != nil {
+		return err
+	}
+
+	ok, err := m.hasSidecar(ctx, key)
+	if err != nil || !ok {
+		return err
+	}
+	return c.Copy(ctx, key+metaExt, dst, dstKey+metaExt)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"ez-snapshot/internal/config"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// newRCloneClient returns the HTTP client used for rc calls, with the
+// configured TLS settings, timeouts and credentials.
+func newRCloneClient(cfg *config.RCloneConfig) (*http.Client, error) {
+	host, err := url.Parse(cfg.Host)
+	if err != nil || host.Host == "" {
+		return nil, fmt.Errorf("invalid rclone.host: %s", cfg.Host)
+	}
+
+	tlsConfig, err := rcloneTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.ConnectTimeout}).DialContext
+	transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	transport.ResponseHeaderTimeout = cfg.ResponseTimeout
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: &rcAuthTransport{
+			base:     transport,
+			host:     host.Host,
+			username: cfg.Username,
+			password: cfg.Password,
+			bearer:   cfg.Bearer,
+		},
+	}, nil
+}
+
+func rcloneTLSConfig(cfg *config.RCloneConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rclone.ca_cert: %w", err)
+		}
+		// public links point to the storage provider, keep trusting it
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, errors.New("rclone.client_cert and rclone.client_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rclone client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// rcAuthTransport authorizes requests sent to the rc API. Other hosts, eg :
+// the public links of a backend, never see the credentials.
+type rcAuthTransport struct {
+	base     http.RoundTripper
+	host     string
+	username string
+	password string
+	bearer   string
+}
+
+func (t *rcAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || (t.username == "" && t.bearer == "") {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if t.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+t.bearer)
+	} else {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	return t.base.RoundTrip(req)
+}
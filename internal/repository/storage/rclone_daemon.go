@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"ez-snapshot/internal/config"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rcloneReadyTimeout is how long a spawned daemon has to answer rc/noop.
+const rcloneReadyTimeout = 15 * time.Second
+
+// RCloneDaemon is an rclone rcd process started by ez-snapshot, listening on
+// localhost with a one-time credential.
+type RCloneDaemon struct {
+	Host     string
+	Username string
+	Password string
+
+	cmd    *exec.Cmd
+	output *tailBuffer
+	exited chan struct{}
+}
+
+// RCloneReachable reports whether the rc API of cfg answers rc/noop.
+func RCloneReachable(ctx context.Context, cfg *config.RCloneConfig) bool {
+	client, err := newRCloneClient(cfg)
+	if err != nil {
+		return false
+	}
+	return rcNoop(ctx, client, strings.TrimSuffix(cfg.Host, "/")) == nil
+}
+
+func rcNoop(ctx context.Context, client *http.Client, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, host+"/rc/noop", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rc/noop failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// SpawnRClone starts rclone rcd and waits until it is ready. The credential
+// is passed through the environment so it doesn't show up in ps.
+func SpawnRClone(ctx context.Context, cfg *config.RCloneConfig) (*RCloneDaemon, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &RCloneDaemon{
+		Host:     fmt.Sprintf("http://127.0.0.1:%d", port),
+		Username: randomToken(),
+		Password: randomToken(),
+		output:   &tailBuffer{max: 4096},
+		exited:   make(chan struct{}),
+	}
+
+	args := []string{"rcd", fmt.Sprintf("--rc-addr=127.0.0.1:%d", port), "--rc-serve"}
+	if cfg.ConfigFile != "" {
+		args = append(args, "--config", cfg.ConfigFile)
+	}
+
+	d.cmd = exec.Command("rclone", args...)
+	d.cmd.Env = append(os.Environ(), "RCLONE_RC_USER="+d.Username, "RCLONE_RC_PASS="+d.Password)
+	d.cmd.Stdout = d.output
+	d.cmd.Stderr = d.output
+	d.cmd.SysProcAttr = daemonProcAttr()
+
+	if err := d.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start rclone rcd: %w", err)
+	}
+	go func() {
+		_ = d.cmd.Wait()
+		close(d.exited)
+	}()
+
+	if err := d.waitReady(ctx); err != nil {
+		d.Stop()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *RCloneDaemon) waitReady(ctx context.Context) error {
+	client, err := newRCloneClient(&config.RCloneConfig{
+		Host:           d.Host,
+		Username:       d.Username,
+		Password:       d.Password,
+		ConnectTimeout: time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rcloneReadyTimeout)
+	defer cancel()
+
+	for {
+		if err = rcNoop(ctx, client, d.Host); err == nil {
+			return nil
+		}
+
+		select {
+		case <-d.exited:
+			return fmt.Errorf("rclone rcd exited: %s", strings.TrimSpace(d.output.String()))
+		case <-ctx.Done():
+			return fmt.Errorf("rclone rcd is not ready after %s: %w", rcloneReadyTimeout, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Stop terminates the daemon, it is killed if it doesn't exit in time.
+func (d *RCloneDaemon) Stop() {
+	if d == nil {
+		return
+	}
+
+	select {
+	case <-d.exited:
+		return
+	default:
+	}
+
+	if err := d.cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = d.cmd.Process.Kill()
+	}
+	select {
+	case <-d.exited:
+	case <-time.After(5 * time.Second):
+		_ = d.cmd.Process.Kill()
+		<-d.exited
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// tailBuffer keeps the last bytes written to it, enough to report why the
+// daemon failed to start.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf.Write(p)
+	if extra := t.buf.Len() - t.max; extra > 0 {
+		t.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.String()
+}
@@ -0,0 +1,9 @@
+package storage
+
+import "syscall"
+
+// daemonProcAttr keeps Ctrl+C away from the daemon, ez-snapshot stops it once
+// done, and makes the kernel stop it if ez-snapshot dies first.
+func daemonProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGTERM}
+}
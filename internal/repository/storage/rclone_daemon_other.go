@@ -0,0 +1,9 @@
+//go:build !linux
+
+package storage
+
+import "syscall"
+
+func daemonProcAttr() *syscall.SysProcAttr {
+	return nil
+}
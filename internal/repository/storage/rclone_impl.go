@@ -3,33 +3,73 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"ez-snapshot/internal/config"
 	"ez-snapshot/internal/entity"
 )
 
 type rCloneImpl struct {
-	host   string
-	fs     string
-	remote string
-	client *http.Client
+	host       string
+	fs         string
+	remote     string
+	stagingDir string
+	async      bool
+	client     *http.Client
+
+	bwlimit *BandwidthLimit
+	mu      sync.Mutex
+	rate    string // last limit pushed to the daemon
 }
 
-func newRCloneImpl(host, fs, remote string) Repository {
-	return &rCloneImpl{
-		host:   host, // e.g. "http://localhost:5572"
-		fs:     fs,   // e.g. "s3remote:mybucket"
-		remote: remote,
-		client: &http.Client{},
+func newRCloneImpl(cfg *config.RCloneConfig, bwlimit *BandwidthLimit) (Repository, error) {
+	client, err := newRCloneClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var async bool
+	switch cfg.TransferMode {
+	case "", "stream":
+	case "async":
+		if cfg.StagingDir == "" {
+			return nil, errors.New("rclone.transfer_mode async requires rclone.staging_dir")
+		}
+		async = true
+	default:
+		return nil, fmt.Errorf("unknown rclone.transfer_mode: %s", cfg.TransferMode)
 	}
+
+	return &rCloneImpl{
+		host:       strings.TrimSuffix(cfg.Host, "/"), // e.g. "http://localhost:5572"
+		fs:         cfg.Fs,                            // e.g. "s3remote:mybucket"
+		remote:     cfg.Remote,
+		stagingDir: cfg.StagingDir,
+		async:      async,
+		client:     client,
+		bwlimit:    bwlimit,
+	}, nil
 }
 
-func (rc *rCloneImpl) Upload(ctx context.Context, key string, r io.Reader) (string, error) {
+// Upload streams the backup with operations/uploadfile. Remotes have no
+// common way to store metadata, it is kept in a sidecar.
+func (rc *rCloneImpl) Upload(ctx context.Context, key string, r io.Reader, _ Metadata) (string, error) {
+	rc.pushBandwidth(ctx)
+	if rc.async {
+		return rc.asyncUpload(ctx, key, r)
+	}
+
 	endpoint := rc.host + "/operations/uploadfile"
 	values := url.Values{}
 	values.Set("fs", rc.fs)
@@ -93,13 +133,35 @@ func (rc *rCloneImpl) Upload(ctx context.Context, key string, r io.Reader) (stri
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("upload failed: %s", string(b))
+		return "", withStatus(resp, fmt.Errorf("upload failed: %s", string(b)))
 	}
 
 	fmt.Println("✅ Backup has been uploaded")
 	return key, nil
 }
 
+// pushBandwidth sets the bandwidth limit of the daemon to the current one of
+// the schedule, transfers rclone runs on its own are limited too. core/bwlimit
+// takes a single rate, it is pushed again once the schedule moves on.
+func (rc *rCloneImpl) pushBandwidth(ctx context.Context) {
+	if rc.bwlimit == nil {
+		return
+	}
+
+	rate := rc.bwlimit.rcloneRate(time.Now())
+	rc.mu.Lock()
+	if rate == rc.rate {
+		rc.mu.Unlock()
+		return
+	}
+	rc.rate = rate
+	rc.mu.Unlock()
+
+	if err := rc.call(ctx, "core/bwlimit", url.Values{"rate": {rate}}, nil); err != nil {
+		fmt.Printf("⚠️ failed to set the rclone bandwidth limit: %v\n", err)
+	}
+}
+
 // progressCounter prints a running byte count without spamming the console.
 type progressCounter struct {
 	n    int64
@@ -116,7 +178,38 @@ func (pc *progressCounter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// Download fetches a backup through a public link when the backend supports
+// it. Backends without public links (local, sftp, plain WebDAV, crypt, ...)
+// fall back to streaming the file from the rc server itself, and finally to
+// copying it into the local staging remote.
+// In async mode the backup is copied into the staging directory by an rclone
+// job instead.
 func (rc *rCloneImpl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc.pushBandwidth(ctx)
+	if rc.async {
+		return rc.asyncDownload(ctx, key)
+	}
+
+	body, err := rc.publicLinkDownload(ctx, key)
+	if err == nil {
+		return body, nil
+	}
+	fmt.Printf("Public link not available (%v), streaming from rclone instead\n", err)
+
+	body, err = rc.serveDownload(ctx, key)
+	if err == nil {
+		return body, nil
+	}
+
+	if rc.stagingDir == "" {
+		return nil, fmt.Errorf("%w (start rclone rcd with --rc-serve or set rclone.staging_dir)", err)
+	}
+	fmt.Printf("rc-serve not available (%v), copying to staging directory instead\n", err)
+
+	return rc.copyFileDownload(ctx, key)
+}
+
+func (rc *rCloneImpl) publicLinkDownload(ctx context.Context, key string) (io.ReadCloser, error) {
 	endpoint := rc.host + "/operations/publiclink"
 	values := url.Values{}
 	values.Set("fs", rc.fs)
@@ -135,7 +228,7 @@ func (rc *rCloneImpl) Download(ctx context.Context, key string) (io.ReadCloser,
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("publiclink failed: %s", string(b))
+		return nil, withStatus(resp, fmt.Errorf("publiclink failed: %s", string(b)))
 	}
 
 	var result struct {
@@ -155,18 +248,116 @@ func (rc *rCloneImpl) Download(ctx context.Context, key string) (io.ReadCloser,
 		return nil, err
 	}
 
-	fileResp, err := rc.client.Do(fileReq)
+	return rc.get(fileReq)
+}
+
+// serveDownload streams the file from an rc server started with --rc-serve,
+// which exposes every remote under /[fs]/path.
+func (rc *rCloneImpl) serveDownload(ctx context.Context, key string) (io.ReadCloser, error) {
+	endpoint := rc.host + "/" + url.PathEscape("["+rc.fs+"]") + "/" + escapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return rc.get(req)
+}
+
+// copyFileDownload copies the file into the staging directory with
+// operations/copyfile and opens it from there. The staging directory must be
+// shared between ez-snapshot and the rclone daemon.
+func (rc *rCloneImpl) copyFileDownload(ctx context.Context, key string) (io.ReadCloser, error) {
+	name := path.Base(key)
+
+	endpoint := rc.host + "/operations/copyfile"
+	values := url.Values{}
+	values.Set("srcFs", rc.fs)
+	values.Set("srcRemote", key)
+	values.Set("dstFs", rc.stagingDir)
+	values.Set("dstRemote", name)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, withStatus(resp, fmt.Errorf("copyfile failed: %s", string(b)))
+	}
+
+	f, err := os.Open(filepath.Join(rc.stagingDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("staged file is not readable: %w", err)
+	}
+
+	return &stagedFile{File: f}, nil
+}
+
+// get executes a download request and returns its body on success.
+func (rc *rCloneImpl) get(req *http.Request) (io.ReadCloser, error) {
+	resp, err := rc.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	if fileResp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(fileResp.Body)
-		fileResp.Body.Close()
-		return nil, fmt.Errorf("download failed: %s", string(b))
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, withStatus(resp, fmt.Errorf("download failed: %s", string(b)))
+	}
+
+	return resp.Body, nil // caller must Close()
+}
+
+// stagedFile removes the staged copy once the caller is done with it.
+type stagedFile struct {
+	*os.File
+}
+
+func (f *stagedFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+// escapePath escapes every segment of a slash separated path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Copy copies a backup to another remote of the same rclone daemon with
+// operations/copyfile, the data never leaves rclone.
+func (rc *rCloneImpl) Copy(ctx context.Context, key string, dst Repository, dstKey string) error {
+	d, ok := baseRepo(dst).(*rCloneImpl)
+	if !ok || d.host != rc.host {
+		return ErrCopyNotSupported
+	}
+
+	params := url.Values{
+		"srcFs":     {rc.fs},
+		"srcRemote": {key},
+		"dstFs":     {d.fs},
+		"dstRemote": {path.Join(d.remote, dstKey)},
 	}
 
-	return fileResp.Body, nil // caller must Close()
+	rc.pushBandwidth(ctx)
+	fmt.Printf("Begin server-side copy of %s to %s/%s\n", key, d.remote, dstKey)
+	if rc.async {
+		return rc.runJob(ctx, "operations/copyfile", params, "Copied")
+	}
+	return rc.call(ctx, "operations/copyfile", params, nil)
 }
 
 func (rc *rCloneImpl) Delete(ctx context.Context, key string) error {
@@ -188,12 +379,48 @@ func (rc *rCloneImpl) Delete(ctx context.Context, key string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete failed: %s", string(b))
+		return withStatus(resp, fmt.Errorf("delete failed: %s", string(b)))
 	}
 
 	return nil
 }
 
+// rcloneItem is an entry of operations/list and operations/stat.
+type rcloneItem struct {
+	Path     string    `json:"Path"`
+	Name     string    `json:"Name"`
+	Size     int64     `json:"Size"`
+	MimeType string    `json:"MimeType"`
+	ModTime  time.Time `json:"ModTime"`
+	IsDir    bool      `json:"IsDir"`
+	Tier     string    `json:"Tier"`
+}
+
+func (i *rcloneItem) backup() *entity.Backup {
+	return &entity.Backup{
+		Path:     i.Path,
+		Name:     i.Name,
+		Size:     i.Size,
+		MimeType: i.MimeType,
+		ModTime:  i.ModTime,
+		IsDir:    i.IsDir,
+		Tier:     i.Tier,
+	}
+}
+
+func (rc *rCloneImpl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	var result struct {
+		Item *rcloneItem `json:"item"`
+	}
+	if err := rc.call(ctx, "operations/stat", url.Values{"fs": {rc.fs}, "remote": {key}}, &result); err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return result.Item.backup(), nil
+}
+
 func (rc *rCloneImpl) List(ctx context.Context) ([]*entity.Backup, error) {
 	endpoint := rc.host + "/operations/list"
 	values := url.Values{}
@@ -213,11 +440,11 @@ func (rc *rCloneImpl) List(ctx context.Context) ([]*entity.Backup, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("list failed: %s", string(b))
+		return nil, withStatus(resp, fmt.Errorf("list failed: %s", string(b)))
 	}
 
 	var result struct {
-		List []*entity.Backup `json:"list"`
+		List []*rcloneItem `json:"list"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -229,7 +456,7 @@ func (rc *rCloneImpl) List(ctx context.Context) ([]*entity.Backup, error) {
 		if f.IsDir {
 			continue
 		}
-		backups = append(backups, f)
+		backups = append(backups, f.backup())
 	}
 
 	return backups, nil
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobPollInterval is how often the status of an rclone job is polled.
+const jobPollInterval = time.Second
+
+// jobMaxPollFailures is how many polls of the job status can fail in a row,
+// eg : while the rc API is unreachable, before the job is given up.
+const jobMaxPollFailures = 30
+
+// call sends an rc command and decodes its JSON response into out, if set.
+func (rc *rCloneImpl) call(ctx context.Context, command string, params url.Values, out any) error {
+	endpoint := rc.host + "/" + command
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return withStatus(resp, fmt.Errorf("%s failed: %s", command, string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// asyncUpload stages the backup in the staging directory, then lets rclone
+// copy it to the remote as a job. rclone retries the transfer itself, a
+// dropped rc connection only interrupts the polling.
+func (rc *rCloneImpl) asyncUpload(ctx context.Context, key string, r io.Reader) (string, error) {
+	name := fmt.Sprintf(".%s.tmp-%d", key, time.Now().UnixNano())
+	staged := filepath.Join(rc.stagingDir, name)
+
+	fmt.Printf("Staging backup in %s\n", staged)
+	f, err := os.Create(staged)
+	if err != nil {
+		return "", fmt.Errorf("staging directory is not writable: %w", err)
+	}
+	defer os.Remove(staged)
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Begin upload to %s/%s\n", rc.remote, key)
+	err = rc.runJob(ctx, "operations/copyfile", url.Values{
+		"srcFs":     {rc.stagingDir},
+		"srcRemote": {name},
+		"dstFs":     {rc.fs},
+		"dstRemote": {path.Join(rc.remote, key)},
+	}, "Uploaded")
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+// asyncDownload lets rclone copy the backup into the staging directory as a
+// job and opens it from there.
+func (rc *rCloneImpl) asyncDownload(ctx context.Context, key string) (io.ReadCloser, error) {
+	name := fmt.Sprintf(".%s.tmp-%d", path.Base(key), time.Now().UnixNano())
+
+	fmt.Printf("Begin download of %s\n", key)
+	err := rc.runJob(ctx, "operations/copyfile", url.Values{
+		"srcFs":     {rc.fs},
+		"srcRemote": {key},
+		"dstFs":     {rc.stagingDir},
+		"dstRemote": {name},
+	}, "Downloaded")
+	if err != nil {
+		_ = os.Remove(filepath.Join(rc.stagingDir, name))
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(rc.stagingDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("staged file is not readable: %w", err)
+	}
+	return &stagedFile{File: f}, nil
+}
+
+type jobStatus struct {
+	Finished bool   `json:"finished"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error"`
+}
+
+type jobStats struct {
+	Bytes      int64    `json:"bytes"`
+	TotalBytes int64    `json:"totalBytes"`
+	Speed      float64  `json:"speed"`
+	Eta        *float64 `json:"eta"`
+}
+
+// runJob starts command as an async job and waits for it, printing its
+// progress. The job is stopped if ctx is cancelled.
+func (rc *rCloneImpl) runJob(ctx context.Context, command string, params url.Values, verb string) error {
+	params.Set("_async", "true")
+
+	var started struct {
+		JobID int64 `json:"jobid"`
+	}
+	if err := rc.call(ctx, command, params, &started); err != nil {
+		return err
+	}
+	jobID := strconv.FormatInt(started.JobID, 10)
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	var stats jobStats
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			defer cancel()
+			if err := rc.call(stopCtx, "job/stop", url.Values{"jobid": {jobID}}, nil); err != nil {
+				fmt.Printf("\n⚠️ failed to stop rclone job %s: %v\n", jobID, err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		rc.pushBandwidth(ctx)
+
+		// stats are only informative, a failed poll is retried on the next tick
+		_ = rc.call(ctx, "core/stats", url.Values{"group": {"job/" + jobID}}, &stats)
+		printJobProgress(verb, stats)
+
+		var status jobStatus
+		if err := rc.call(ctx, "job/status", url.Values{"jobid": {jobID}}, &status); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			// the job ids are lost when the rc daemon restarts
+			if strings.Contains(err.Error(), "job not found") {
+				fmt.Println()
+				return fmt.Errorf("rclone job %s is gone, the rc daemon may have restarted: %w", jobID, err)
+			}
+			if failures++; failures >= jobMaxPollFailures {
+				fmt.Println()
+				return fmt.Errorf("rclone job %s can't be polled: %w", jobID, err)
+			}
+			fmt.Printf("\n⚠️ failed to poll rclone job %s: %v\n", jobID, err)
+			continue
+		}
+		failures = 0
+		if !status.Finished {
+			continue
+		}
+
+		fmt.Println()
+		if !status.Success {
+			if status.Error == "" {
+				return errors.New(command + " job failed")
+			}
+			return fmt.Errorf("%s job failed: %s", command, status.Error)
+		}
+		return nil
+	}
+}
+
+func printJobProgress(verb string, s jobStats) {
+	line := fmt.Sprintf("\r%s %d", verb, s.Bytes)
+	if s.TotalBytes > 0 {
+		line += fmt.Sprintf(" / %d", s.TotalBytes)
+	}
+	line += fmt.Sprintf(" bytes, %.1f MiB/s", s.Speed/(1<<20))
+	if s.Eta != nil {
+		line += fmt.Sprintf(", ETA %s", (time.Duration(*s.Eta) * time.Second).String())
+	}
+	fmt.Print(line + "   ")
+}
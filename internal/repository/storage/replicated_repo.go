@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// replica is one storage target of a replicatedRepo.
+type replica struct {
+	name string
+	repo Repository
+}
+
+// replicatedRepo stores every backup in several targets, eg : a primary
+// bucket and an offsite server. Uploads are streamed to all of them at once,
+// downloads fall back to the next target. Restores verify each target in turn,
+// see Targets.
+type replicatedRepo struct {
+	replicas    []replica
+	minReplicas int // 0 requires all of them
+}
+
+func newReplicatedRepo(replicas []replica, minReplicas int) Repository {
+	return &replicatedRepo{replicas: replicas, minReplicas: minReplicas}
+}
+
+// Target is one storage target a backup can be read from.
+type Target struct {
+	Name string
+	Repository
+}
+
+// Targets returns the targets of repo in the order downloads try them, repo
+// itself when it isn't replicated. Reading a backup and its sidecars from the
+// same target keeps them consistent.
+func Targets(repo Repository) []Target {
+	r, ok := repo.(*replicatedRepo)
+	if !ok {
+		return []Target{{Repository: repo}}
+	}
+
+	targets := make([]Target, len(r.replicas))
+	for i, rep := range r.replicas {
+		targets[i] = Target{Name: rep.name, Repository: rep.repo}
+	}
+	return targets
+}
+
+func (r *replicatedRepo) required() int {
+	if r.minReplicas == 0 {
+		return len(r.replicas)
+	}
+	return r.minReplicas
+}
+
+// Upload sends the backup to every target concurrently and applies the
+// replica policy.
+func (r *replicatedRepo) Upload(ctx context.Context, key string, src io.Reader, meta Metadata) (string, error) {
+	names := make([]string, len(r.replicas))
+	for i, rep := range r.replicas {
+		names[i] = rep.name
+	}
+	fmt.Printf("Replicating %s to %s\n", key, strings.Join(names, ", "))
+
+	var errs []error
+	if f, ok := src.(readerAtSeeker); ok {
+		var err error
+		if errs, err = r.uploadSections(ctx, key, f, meta); err != nil {
+			return "", err
+		}
+	} else {
+		var copyErr error
+		errs, copyErr = r.uploadStreams(ctx, key, src, meta)
+
+		// when every target failed their own errors tell why
+		if copyErr != nil && !errors.Is(copyErr, errTargetsFailed) {
+			return "", copyErr
+		}
+	}
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.replicas[i].name, err))
+		}
+	}
+	stored := len(r.replicas) - len(failed)
+
+	if stored < r.required() {
+		return "", fmt.Errorf("backup reached %d of %d required target(s): %s", stored, r.required(), strings.Join(failed, "; "))
+	}
+	for _, f := range failed {
+		fmt.Printf("⚠️ replica %s\n", f)
+	}
+	fmt.Printf("✅ Backup has been replicated to %d of %d target(s)\n", stored, len(r.replicas))
+	return key, nil
+}
+
+// readerAtSeeker is a source every target can read on its own, eg : a file.
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// uploadSections uploads the rest of f to every target concurrently, each
+// target reading its own section so it can rewind it to retry.
+func (r *replicatedRepo) uploadSections(ctx context.Context, key string, f readerAtSeeker, meta Metadata) ([]error, error) {
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(r.replicas))
+	var wg sync.WaitGroup
+	for i, rep := range r.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = rep.repo.Upload(ctx, key, io.NewSectionReader(f, start, end-start), meta)
+		}()
+	}
+	wg.Wait()
+
+	return errs, nil
+}
+
+// uploadStreams streams src to every target concurrently. A target failing
+// midway stops receiving data while the others continue.
+func (r *replicatedRepo) uploadStreams(ctx context.Context, key string, src io.Reader, meta Metadata) ([]error, error) {
+	writers := make([]*io.PipeWriter, len(r.replicas))
+	errs := make([]error, len(r.replicas))
+	var wg sync.WaitGroup
+
+	for i, rep := range r.replicas {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := rep.repo.Upload(ctx, key, pr, meta)
+			if err == nil {
+				// the target must have read everything
+				_, err = pr.Read(make([]byte, 1))
+				if err == io.EOF {
+					err = nil
+				} else if err == nil {
+					err = errors.New("upload returned before the end of the backup")
+				}
+			}
+			errs[i] = err
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			pr.CloseWithError(err)
+		}()
+	}
+
+	_, copyErr := io.Copy(&fanOut{writers: writers}, src)
+	for _, pw := range writers {
+		pw.CloseWithError(copyErr)
+	}
+	wg.Wait()
+
+	return errs, copyErr
+}
+
+var errTargetsFailed = errors.New("every target failed")
+
+// fanOut writes to every pipe whose reader is still reading.
+type fanOut struct {
+	writers []*io.PipeWriter
+	dead    []bool
+}
+
+func (f *fanOut) Write(p []byte) (int, error) {
+	if f.dead == nil {
+		f.dead = make([]bool, len(f.writers))
+	}
+
+	alive := false
+	for i, w := range f.writers {
+		if f.dead[i] {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.dead[i] = true
+			continue
+		}
+		alive = true
+	}
+	if !alive {
+		return 0, errTargetsFailed
+	}
+	return len(p), nil
+}
+
+// Download reads the backup from the first target able to serve it.
+func (r *replicatedRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	var errs []error
+	for i, rep := range r.replicas {
+		rc, err := rep.repo.Download(ctx, key)
+		if err == nil {
+			return rc, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rep.name, err))
+
+		if i < len(r.replicas)-1 {
+			fmt.Printf("⚠️ download from %s failed (%v), trying %s\n", rep.name, err, r.replicas[i+1].name)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Stat returns the backup as stored by the first target holding it.
+func (r *replicatedRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	var errs []error
+	for _, rep := range r.replicas {
+		b, err := rep.repo.Stat(ctx, key)
+		if err == nil {
+			return b, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rep.name, err))
+	}
+
+	// only missing when no target could have it
+	var failed []error
+	for _, err := range errs {
+		if !errors.Is(err, ErrNotFound) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return nil, errors.Join(failed...)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Delete removes the backup from every target, it only fails when no target
+// could delete it.
+func (r *replicatedRepo) Delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, rep := range r.replicas {
+		if err := rep.repo.Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rep.name, err))
+		}
+	}
+
+	if len(errs) == len(r.replicas) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		fmt.Printf("⚠️ delete from %v\n", err)
+	}
+	return nil
+}
+
+// List merges the backups of every target, Replicas tells which targets hold
+// each of them. Unreachable targets are skipped.
+func (r *replicatedRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	lists := make([][]*entity.Backup, len(r.replicas))
+	errs := make([]error, len(r.replicas))
+
+	var wg sync.WaitGroup
+	for i, rep := range r.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i], errs[i] = rep.repo.List(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var backups []*entity.Backup
+	byName := map[string]*entity.Backup{}
+	reachable := 0
+
+	for i, list := range lists {
+		if errs[i] != nil {
+			fmt.Printf("⚠️ %s is not reachable: %v\n", r.replicas[i].name, errs[i])
+			continue
+		}
+		reachable++
+
+		for _, b := range list {
+			if existing, ok := byName[b.Name]; ok {
+				existing.Replicas = append(existing.Replicas, r.replicas[i].name)
+				continue
+			}
+			b.Replicas = []string{r.replicas[i].name}
+			byName[b.Name] = b
+			backups = append(backups, b)
+		}
+	}
+
+	if reachable == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return backups, nil
+}
+
+// GC collects every target using a layout that can leave unreferenced
+// objects behind.
+func (r *replicatedRepo) GC(ctx context.Context) (int, error) {
+	total, collected := 0, false
+	for _, rep := range r.replicas {
+		gc, ok := rep.repo.(GarbageCollector)
+		if !ok {
+			continue
+		}
+		collected = true
+
+		n, err := gc.GC(ctx)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", rep.name, err)
+		}
+		total += n
+	}
+
+	if !collected {
+		return 0, errors.New("the configured storage layout has nothing to collect")
+	}
+	return total, nil
+}
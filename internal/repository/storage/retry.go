@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// retryPolicy retries the operations failing with a transient error,
+// waiting an exponential backoff with jitter between attempts.
+type retryPolicy struct {
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// do runs fn until it succeeds, fails with a permanent error or runs out of
+// attempts.
+func (p retryPolicy) do(ctx context.Context, op string, fn func() error) error {
+	backoff := p.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		// half of the backoff is random so that clients don't retry in sync
+		wait := backoff/2 + rand.N(backoff/2+1)
+		fmt.Printf("\n⚠️ %s failed (%v), retrying in %s (%d/%d)\n", op, err, wait.Round(time.Millisecond), attempt, p.attempts-1)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, p.maxBackoff)
+	}
+}
+
+// statusError is an unexpected HTTP response, its status tells whether the
+// request is worth retrying.
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+func withStatus(resp *http.Response, err error) error {
+	return &statusError{code: resp.StatusCode, err: err}
+}
+
+// retryable reports whether err is transient: a throttled or unavailable
+// server, a timeout or a dropped connection.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.code {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost)
+}
+
+// resumableUploader is implemented by storages uploading in parts, they
+// retry a failed part themselves instead of restarting the whole upload.
+type resumableUploader interface {
+	setRetry(p retryPolicy)
+}
+
+// retryRepo retries the operations of a storage. Uploads are only retried
+// when the reader can be rewound, eg : a file.
+type retryRepo struct {
+	inner  Repository
+	policy retryPolicy
+}
+
+func newRetryRepo(inner Repository, policy retryPolicy) Repository {
+	if ru, ok := inner.(resumableUploader); ok {
+		ru.setRetry(policy)
+	}
+	return &retryRepo{inner: inner, policy: policy}
+}
+
+func (r *retryRepo) Upload(ctx context.Context, key string, body io.Reader, meta Metadata) (string, error) {
+	seeker, ok := body.(io.Seeker)
+	if _, resumable := r.inner.(resumableUploader); !ok || resumable {
+		return r.inner.Upload(ctx, key, body, meta)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return r.inner.Upload(ctx, key, body, meta)
+	}
+
+	var out string
+	err = r.policy.do(ctx, "upload of "+key, func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		var err error
+		out, err = r.inner.Upload(ctx, key, body, meta)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := r.policy.do(ctx, "download of "+key, func() error {
+		var err error
+		body, err = r.inner.Download(ctx, key)
+		return err
+	})
+	return body, err
+}
+
+func (r *retryRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	var b *entity.Backup
+	err := r.policy.do(ctx, "stat of "+key, func() error {
+		var err error
+		b, err = r.inner.Stat(ctx, key)
+		return err
+	})
+	return b, err
+}
+
+func (r *retryRepo) Delete(ctx context.Context, key string) error {
+	return r.policy.do(ctx, "delete of "+key, func() error {
+		return r.inner.Delete(ctx, key)
+	})
+}
+
+func (r *retryRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	var list []*entity.Backup
+	err := r.policy.do(ctx, "list", func() error {
+		var err error
+		list, err = r.inner.List(ctx)
+		return err
+	})
+	return list, err
+}
+
+// Copy copies server-side when the wrapped storage supports it.
+func (r *retryRepo) Copy(ctx context.Context, key string, dst Repository, dstKey string) error {
+	c, ok := r.inner.(Copier)
+	if !ok {
+		return ErrCopyNotSupported
+	}
+	return r.policy.do(ctx, "copy of "+key, func() error {
+		return c.Copy(ctx, key, dst, dstKey)
+	})
+}
@@ -0,0 +1,437 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPartSize is the smallest part S3 accepts, except for the last one.
+const minPartSize = 5 << 20
+
+const (
+	// s3MaxParts is the most parts a multipart upload can hold.
+	s3MaxParts = 10000
+	// s3MaxPartSize is the largest part S3 accepts.
+	s3MaxPartSize = 5 << 30
+	// s3PartGrowth is how many parts are uploaded before the part size
+	// doubles, objects up to the 5 TiB S3 allows then fit in s3MaxParts.
+	s3PartGrowth = 1000
+)
+
+// s3PartSize returns the size of the part number, counted from 1.
+func s3PartSize(base int64, number int) int64 {
+	size := base << ((number - 1) / s3PartGrowth)
+	if size <= 0 || size > s3MaxPartSize {
+		return s3MaxPartSize
+	}
+	return size
+}
+
+// downloadRetries is how many times a download interrupted midway is resumed
+// with a ranged GET.
+const downloadRetries = 3
+
+// s3Impl talks to the S3 API directly, it works with AWS and S3 compatible
+// servers such as MinIO.
+type s3Impl struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	pathStyle bool
+	signer    sigV4
+
+	storageClass string
+	sse          string
+	sseKMSKeyID  string
+	partSize     int64
+
+	client *http.Client
+	retry  retryPolicy // of every upload request
+}
+
+func newS3Impl(cfg *config.S3Config) (*s3Impl, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3.bucket is required by the s3 storage")
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3.endpoint: %s", endpoint)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &s3Impl{
+		endpoint:  u,
+		bucket:    cfg.Bucket,
+		prefix:    prefix,
+		pathStyle: cfg.PathStyle,
+		signer: sigV4{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+			region:          cfg.Region,
+			service:         "s3",
+		},
+		storageClass: cfg.StorageClass,
+		sse:          cfg.SSE,
+		sseKMSKeyID:  cfg.SSEKMSKeyID,
+		partSize:     max(cfg.PartSize, minPartSize),
+		client:       &http.Client{},
+	}, nil
+}
+
+// objectURL returns the URL of the object key (relative to the prefix), or
+// of the bucket when key is empty.
+func (s *s3Impl) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := "/" + s.prefix + key
+	if key == "" {
+		path = "/"
+	}
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do signs and sends a request, non 2xx responses are turned into errors.
+func (s *s3Impl) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	hash := sha256.Sum256(body)
+	s.signer.sign(req, hex.EncodeToString(hash[:]), time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3Error(method, resp)
+	}
+	return resp, nil
+}
+
+func (s *s3Impl) setRetry(p retryPolicy) {
+	s.retry = p
+}
+
+// send is do retried on its own, a failed part is sent again instead of
+// restarting the upload.
+func (s *s3Impl) send(ctx context.Context, op, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	err := s.retry.do(ctx, op, func() error {
+		var err error
+		resp, err = s.do(ctx, method, u, header, body)
+		return err
+	})
+	return resp, err
+}
+
+func s3Error(method string, resp *http.Response) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		return withStatus(resp, fmt.Errorf("s3 %s failed: %s: %s", method, e.Code, e.Message))
+	}
+	return withStatus(resp, fmt.Errorf("s3 %s failed: %s", method, resp.Status))
+}
+
+// uploadHeaders are sent when an object is created.
+func (s *s3Impl) uploadHeaders(meta Metadata) http.Header {
+	h := http.Header{}
+	h.Set("Content-Type", "application/octet-stream")
+	setMetadataHeaders(h, "X-Amz-Meta-", meta)
+	if s.storageClass != "" {
+		h.Set("X-Amz-Storage-Class", s.storageClass)
+	}
+	if s.sse != "" {
+		h.Set("X-Amz-Server-Side-Encryption", s.sse)
+	}
+	if s.sseKMSKeyID != "" {
+		h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.sseKMSKeyID)
+	}
+	return h
+}
+
+// Upload sends objects smaller than a part with a single PUT, larger ones
+// are streamed as a multipart upload holding one part in memory at a time.
+func (s *s3Impl) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	fmt.Printf("Begin upload to s3://%s/%s%s\n", s.bucket, s.prefix, key)
+
+	pc := &progressCounter{}
+	r = io.TeeReader(r, pc)
+
+	part := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, part)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	if n < len(part) {
+		resp, err := s.send(ctx, "s3 upload", http.MethodPut, s.objectURL(key, nil), s.uploadHeaders(meta), part[:n])
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	} else if err := s.multipartUpload(ctx, key, r, part, meta); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *s3Impl) multipartUpload(ctx context.Context, key string, r io.Reader, first []byte, meta Metadata) error {
+	resp, err := s.send(ctx, "s3 multipart upload", http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), s.uploadHeaders(meta), nil)
+	if err != nil {
+		return err
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("invalid multipart upload response: %w", err)
+	}
+
+	uploadID := created.UploadID
+	if err := s.uploadParts(ctx, key, uploadID, r, first); err != nil {
+		// don't leave billed parts behind
+		abortURL := s.objectURL(key, url.Values{"uploadId": {uploadID}})
+		if resp, aerr := s.do(context.WithoutCancel(ctx), http.MethodDelete, abortURL, nil, nil); aerr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *s3Impl) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, part []byte) error {
+	var parts []completedPart
+	n := len(part)
+
+	for number := 1; n > 0; number++ {
+		if number > s3MaxParts {
+			return fmt.Errorf("object is larger than the %d parts of a multipart upload, raise s3.part_size", s3MaxParts)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := s.send(ctx, fmt.Sprintf("s3 upload of part %d", number), http.MethodPut, s.objectURL(key, query), nil, part[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		if size := s3PartSize(s.partSize, number+1); size != int64(len(part)) {
+			part = make([]byte, size)
+		}
+		var rerr error
+		n, rerr = io.ReadFull(r, part)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.send(ctx, "s3 complete multipart upload", http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// errors after the upload started are reported with a 200 status
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(b, []byte("<Error>")) {
+		return fmt.Errorf("s3 complete multipart upload failed: %s", string(b))
+	}
+	return nil
+}
+
+// Download streams the object, a connection dropped midway is resumed with a
+// ranged GET of the same object version.
+func (s *s3Impl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Reader{ctx: ctx, s: s, key: key, body: resp.Body, etag: resp.Header.Get("ETag")}, nil
+}
+
+type s3Reader struct {
+	ctx     context.Context
+	s       *s3Impl
+	key     string
+	etag    string
+	body    io.ReadCloser
+	offset  int64
+	retries int
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF || r.ctx.Err() != nil || r.retries >= downloadRetries {
+		return n, err
+	}
+
+	r.retries++
+	r.body.Close()
+
+	h := http.Header{}
+	h.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	if r.etag != "" {
+		h.Set("If-Match", r.etag)
+	}
+	resp, rerr := r.s.do(r.ctx, http.MethodGet, r.s.objectURL(r.key, nil), h, nil)
+	if rerr != nil {
+		r.body = io.NopCloser(bytes.NewReader(nil))
+		return n, fmt.Errorf("%w (resume failed: %v)", err, rerr)
+	}
+	r.body = resp.Body
+	return n, nil
+}
+
+func (r *s3Reader) Close() error {
+	return r.body.Close()
+}
+
+// Stat reads the headers of the object, its metadata included.
+func (s *s3Impl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &entity.Backup{
+		Path:     key,
+		Name:     key,
+		Size:     resp.ContentLength,
+		MimeType: resp.Header.Get("Content-Type"),
+		ModTime:  modTime,
+		Tier:     resp.Header.Get("X-Amz-Storage-Class"),
+		Metadata: metadataHeaders(resp.Header, "X-Amz-Meta-"),
+	}, nil
+}
+
+func (s *s3Impl) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List lists the objects directly under the prefix.
+func (s *s3Impl) List(ctx context.Context) ([]*entity.Backup, error) {
+	backups := []*entity.Backup{}
+	token := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+				StorageClass string    `xml:"StorageClass"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if name == "" {
+				continue
+			}
+			backups = append(backups, &entity.Backup{
+				Path:    name,
+				Name:    name,
+				Size:    c.Size,
+				ModTime: c.LastModified,
+				Tier:    c.StorageClass,
+			})
+		}
+
+		if !result.IsTruncated {
+			return backups, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
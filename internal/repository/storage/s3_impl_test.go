@@ -0,0 +1,38 @@
+package storage
+
+import "testing"
+
+func TestS3PartSize(t *testing.T) {
+	const base = 8 << 20
+
+	tests := []struct {
+		base   int64
+		number int
+		want   int64
+	}{
+		{base, 1, base},
+		{base, s3PartGrowth, base},
+		{base, s3PartGrowth + 1, 2 * base},
+		{base, 2*s3PartGrowth + 1, 4 * base},
+		{base, s3MaxParts, base << 9},
+		{s3MaxPartSize + 1, 1, s3MaxPartSize},
+		{s3MaxPartSize / 2, s3PartGrowth + 1, s3MaxPartSize},
+		{s3MaxPartSize / 2, 2*s3PartGrowth + 1, s3MaxPartSize},
+	}
+
+	for _, tt := range tests {
+		if got := s3PartSize(tt.base, tt.number); got != tt.want {
+			t.Errorf("s3PartSize(%d, %d) = %d, want %d", tt.base, tt.number, got, tt.want)
+		}
+	}
+
+	// with the default s3.part_size, the parts hold the largest object S3
+	// accepts, 5 TiB
+	var total int64
+	for n := 1; n <= s3MaxParts; n++ {
+		total += s3PartSize(16<<20, n)
+	}
+	if total < 5<<40 {
+		t.Errorf("%d parts of the default size hold %d bytes, less than 5 TiB", s3MaxParts, total)
+	}
+}
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpImpl stores backups in a directory of an SFTP server. The connection
+// is opened on first use and shared by every operation. SFTP requests can't
+// be cancelled, the connection is closed instead when the context of an
+// operation is done, and opened again by the next one.
+type sftpImpl struct {
+	addr   string
+	config *ssh.ClientConfig
+	dir    string
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPImpl(cfg *config.SFTPConfig) (*sftpImpl, error) {
+	if cfg.Host == "" || cfg.Username == "" {
+		return nil, errors.New("sftp.host and sftp.username are required by the sftp storage")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		b, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp key: %w", err)
+		}
+		var signer ssh.Signer
+		if cfg.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(cfg.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp key %s: %w", cfg.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp.password or sftp.key_file is required by the sftp storage")
+	}
+
+	hostKeys, err := knownhosts.New(cfg.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	dir := cfg.Path
+	if dir == "" {
+		dir = "."
+	}
+
+	return &sftpImpl{
+		addr: net.JoinHostPort(cfg.Host, cfg.Port),
+		config: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+		dir: dir,
+	}, nil
+}
+
+// connect returns the shared client, the connection is closed if ctx is done
+// before stop is called.
+func (s *sftpImpl) connect(ctx context.Context) (client *sftp.Client, stop func() bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		if err := s.dial(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	conn := s.conn
+	stop = context.AfterFunc(ctx, func() { s.drop(conn) })
+	return s.client, stop, nil
+}
+
+// drop forgets the connection before closing it, so the operations failing
+// because of it never get it back.
+func (s *sftpImpl) drop(conn *ssh.Client) {
+	s.mu.Lock()
+	if s.conn == conn {
+		s.conn, s.client = nil, nil
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// dial opens the connection, s.mu must be held.
+func (s *sftpImpl) dial(ctx context.Context) error {
+	d := net.Dialer{Timeout: s.config.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("sftp connection to %s failed: %w", s.addr, err)
+	}
+
+	// the handshake has no timeout of its own
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(nc, s.addr, s.config)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("sftp connection to %s failed: %w", s.addr, err)
+	}
+
+	conn := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.client = conn, client
+
+	// a dropped connection is opened again by the next operation
+	go func() {
+		_ = conn.Wait()
+		client.Close()
+		s.drop(conn)
+	}()
+
+	return nil
+}
+
+// ctxErr returns the error of ctx when it is done, the failure of an
+// operation is then caused by the connection being closed.
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (s *sftpImpl) path(key string) (string, error) {
+	if strings.Contains(key, "/") || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	return path.Join(s.dir, key), nil
+}
+
+// Upload writes into a hidden temp file renamed once complete, so a partial
+// upload is never listed. Files have no metadata, it is kept in a sidecar.
+func (s *sftpImpl) Upload(ctx context.Context, key string, r io.Reader, _ Metadata) (string, error) {
+	key, err := s.upload(ctx, key, r)
+	return key, ctxErr(ctx, err)
+}
+
+func (s *sftpImpl) upload(ctx context.Context, key string, r io.Reader) (string, error) {
+	dst, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	client, stop, err := s.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer stop()
+
+	if err := client.MkdirAll(s.dir); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Begin upload to %s:%s\n", s.addr, dst)
+
+	tmp := path.Join(s.dir, fmt.Sprintf(".%s.tmp-%d", key, time.Now().UnixNano()))
+	f, err := client.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	pc := &progressCounter{}
+	_, err = f.ReadFrom(io.TeeReader(r, pc))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return "", err
+	}
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+
+	if err := s.rename(client, tmp, dst); err != nil {
+		client.Remove(tmp)
+		return "", err
+	}
+
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+// rename replaces dst atomically when the server supports the OpenSSH
+// posix-rename extension, plain SFTP rename refuses existing targets.
+func (s *sftpImpl) rename(client *sftp.Client, src, dst string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(src, dst)
+	}
+	if err := client.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(src, dst)
+}
+
+func (s *sftpImpl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	client, stop, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(p)
+	if err != nil {
+		stop()
+		return nil, ctxErr(ctx, err)
+	}
+	return &sftpFile{File: f, ctx: ctx, stop: stop}, nil
+}
+
+// sftpFile keeps the connection closed on cancellation until the download is
+// closed.
+type sftpFile struct {
+	*sftp.File
+	ctx  context.Context
+	stop func() bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, ctxErr(f.ctx, err)
+}
+
+func (f *sftpFile) Close() error {
+	f.stop()
+	return f.File.Close()
+}
+
+func (s *sftpImpl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	client, stop, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	info, err := client.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return &entity.Backup{
+		Path:    key,
+		Name:    key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}, nil
+}
+
+func (s *sftpImpl) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	client, stop, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	return ctxErr(ctx, client.Remove(p))
+}
+
+func (s *sftpImpl) List(ctx context.Context) ([]*entity.Backup, error) {
+	client, stop, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	entries, err := client.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		// created on the first upload
+		return []*entity.Backup{}, nil
+	}
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	backups := make([]*entity.Backup, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		backups = append(backups, &entity.Backup{
+			Path:    e.Name(),
+			Name:    e.Name(),
+			Size:    e.Size(),
+			ModTime: e.ModTime(),
+		})
+	}
+	return backups, nil
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"ez-snapshot/internal/config"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSFTPServer serves dir over SFTP on a local port, requests are left
+// unanswered while hang is set.
+type testSFTPServer struct {
+	cfg  *config.SFTPConfig
+	hang atomic.Bool
+}
+
+func newTestSFTPServer(t *testing.T, dir string) *testSFTPServer {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "backup" && string(password) == "s3cret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	addr := l.Addr().(*net.TCPAddr)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, signer.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testSFTPServer{cfg: &config.SFTPConfig{
+		Host:       "127.0.0.1",
+		Port:       strconv.Itoa(addr.Port),
+		Username:   "backup",
+		Password:   "s3cret",
+		KnownHosts: knownHosts,
+		Path:       "backups",
+	}}
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(nc, serverConfig, dir)
+		}
+	}()
+	return srv
+}
+
+func (srv *testSFTPServer) serve(nc net.Conn, serverConfig *ssh.ServerConfig, dir string) {
+	defer nc.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(nc, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(&hangingChannel{Channel: channel, hang: &srv.hang}, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					channel.Close()
+					return
+				}
+				go server.Serve()
+			}
+		}()
+	}
+}
+
+// hangingChannel stops reading requests while hang is set.
+type hangingChannel struct {
+	ssh.Channel
+	hang *atomic.Bool
+}
+
+func (c *hangingChannel) Read(p []byte) (int, error) {
+	for c.hang.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.Channel.Read(p)
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	srv := newTestSFTPServer(t, t.TempDir())
+
+	s, err := newSFTPImpl(srv.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, newMetaSidecarRepo(s), map[string]int{
+		"empty": 0,
+		"small": 1000,
+		"large": 3<<20 + 1000,
+	})
+}
+
+func TestSFTPWrongPassword(t *testing.T) {
+	srv := newTestSFTPServer(t, t.TempDir())
+
+	cfg := *srv.cfg
+	cfg.Password = "wrong"
+	s, err := newSFTPImpl(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List(context.Background()); err == nil {
+		t.Fatal("List succeeded with a wrong password")
+	}
+}
+
+func TestSFTPCancel(t *testing.T) {
+	srv := newTestSFTPServer(t, t.TempDir())
+
+	s, err := newSFTPImpl(srv.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hung server doesn't hold an operation past its deadline
+	srv.hang.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Stat(ctx, "db.sql.gz")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Stat error = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stat is still waiting for the hung server")
+	}
+
+	// the next operation opens a new connection
+	srv.hang.Store(false)
+	if _, err := s.Stat(context.Background(), "db.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat after reconnecting = %v, want ErrNotFound", err)
+	}
+
+	// a download is cancelled while it is read
+	if _, err := s.Upload(context.Background(), "db.sql.gz", io.LimitReader(zeroReader{}, 1<<20), nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	rc, err := s.Download(ctx, "db.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	srv.hang.Store(true)
+	cancel()
+	if _, err := io.ReadAll(rc); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download read error = %v, want context.Canceled", err)
+	}
+	srv.hang.Store(false)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"ez-snapshot/internal/archive"
+	"ez-snapshot/internal/encryption"
+	"time"
+)
+
+type storageOpts struct {
+	codec      archive.Codec
+	level      int
+	encryptor  encryption.Encryptor
+	decryptors []encryption.Decryptor
+	retry      retryPolicy
+	bwlimit    *BandwidthLimit
+}
+
+type Opts func(*storageOpts)
+
+// WithChunkCompression sets the compression of every chunk of the chunked layout.
+func WithChunkCompression(codec archive.Codec, level int) Opts {
+	return func(o *storageOpts) {
+		o.codec = codec
+		o.level = level
+	}
+}
+
+// WithChunkEncryption sets the encryption of every chunk of the chunked layout.
+func WithChunkEncryption(encryptor encryption.Encryptor, decryptors ...encryption.Decryptor) Opts {
+	return func(o *storageOpts) {
+		o.encryptor = encryptor
+		o.decryptors = append(o.decryptors, decryptors...)
+	}
+}
+
+// WithRetry retries the storage operations failing with a transient error up
+// to attempts times, the backoff doubling from initial up to maximum.
+func WithRetry(attempts int, initial, maximum time.Duration) Opts {
+	return func(o *storageOpts) {
+		o.retry = retryPolicy{attempts: attempts, initialBackoff: initial, maxBackoff: maximum}
+	}
+}
+
+// WithBandwidthLimit limits the upload and download rate of every storage,
+// nil is unlimited.
+func WithBandwidthLimit(limit *BandwidthLimit) Opts {
+	return func(o *storageOpts) {
+		o.bwlimit = limit
+	}
+}
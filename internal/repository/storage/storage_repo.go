@@ -2,13 +2,37 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"ez-snapshot/internal/entity"
 	"io"
 )
 
 type Repository interface {
-	Upload(ctx context.Context, key string, r io.Reader) (string, error)
+	// Upload stores r under key with meta attached to it, meta may be nil.
+	Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error)
 	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns the object at key with its metadata, or ErrNotFound.
+	Stat(ctx context.Context, key string) (*entity.Backup, error)
 	Delete(ctx context.Context, key string) error
 	List(ctx context.Context) ([]*entity.Backup, error)
 }
+
+// Metadata is attached to an object when it is uploaded. Keys are lower case
+// letters, digits and underscores so that every storage accepts them, values
+// are printable ASCII.
+type Metadata map[string]string
+
+// ErrNotFound is returned by Stat when the object doesn't exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrCopyNotSupported is returned by Copier when the destination can't be
+// reached from the source storage.
+var ErrCopyNotSupported = errors.New("server-side copy not supported")
+
+// Copier is implemented by storages able to copy an object to another
+// storage without downloading it.
+type Copier interface {
+	// Copy copies the object at key to dst under dstKey, or returns
+	// ErrCopyNotSupported.
+	Copy(ctx context.Context, key string, dst Repository, dstKey string) error
+}
@@ -1,8 +1,34 @@
 package storage
 
-type StorageType int
+import "fmt"
+
+// StorageType is the backend backups are stored on.
+type StorageType string
 
 const (
-	AwsS3 StorageType = 0
-	Gcs   StorageType = 1
+	// Rclone stores backups on any rclone remote through the rc API.
+	Rclone StorageType = "rclone"
+	// Local stores backups in a local directory, eg : an NFS mount.
+	Local StorageType = "local"
+	// AwsS3 stores backups on AWS S3 or an S3 compatible server, eg : MinIO.
+	AwsS3 StorageType = "s3"
+	// Gcs stores backups on Google Cloud Storage.
+	Gcs StorageType = "gcs"
+	// Sftp stores backups in a directory of an SFTP server.
+	Sftp StorageType = "sftp"
+	// Azure stores backups in an Azure Blob Storage container.
+	Azure StorageType = "azure"
+	// WebDAV stores backups in a WebDAV collection.
+	WebDAV StorageType = "webdav"
 )
+
+func ParseStorageType(s string) (StorageType, error) {
+	switch t := StorageType(s); t {
+	case "":
+		return Rclone, nil
+	case Rclone, Local, AwsS3, Gcs, Sftp, Azure, WebDAV:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported storage type: %s", s)
+	}
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// volumeIndexExt is appended to the backup name of its volume index.
+const volumeIndexExt = ".volumes"
+
+// volumeIndex lists the volumes a backup is split into, in order.
+type volumeIndex struct {
+	Version  int      `json:"version"`
+	Size     int64    `json:"size"`
+	Volumes  []string `json:"volumes"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// volumeRepo splits objects larger than maxSize into volumes uploaded as
+// <name>.001, <name>.002, ... next to a <name>.volumes index, for remotes
+// refusing large objects. Smaller objects are uploaded as is.
+type volumeRepo struct {
+	inner   Repository
+	maxSize int64
+}
+
+func newVolumeRepo(inner Repository, maxSize int64) Repository {
+	return &volumeRepo{inner: inner, maxSize: maxSize}
+}
+
+// Upload keeps the metadata of a split backup in its index.
+func (v *volumeRepo) Upload(ctx context.Context, key string, r io.Reader, meta Metadata) (string, error) {
+	br := bufio.NewReader(r)
+	index := volumeIndex{Version: 1, Metadata: meta}
+
+	for {
+		// volumes are staged on disk, whether another volume follows must be
+		// known before naming the first one
+		spool, n, err := v.spool(br)
+		if err != nil {
+			return "", err
+		}
+		index.Size += n
+
+		_, peekErr := br.Peek(1)
+		last := peekErr == io.EOF
+		if peekErr != nil && !last {
+			removeSpool(spool)
+			return "", peekErr
+		}
+
+		if last && len(index.Volumes) == 0 {
+			defer removeSpool(spool)
+			return v.inner.Upload(ctx, key, spool, meta)
+		}
+
+		name := fmt.Sprintf("%s.%03d", key, len(index.Volumes)+1)
+		_, err = v.inner.Upload(ctx, name, spool, nil)
+		removeSpool(spool)
+		if err != nil {
+			return "", fmt.Errorf("volume upload failed: %w", err)
+		}
+		index.Volumes = append(index.Volumes, name)
+
+		if last {
+			break
+		}
+	}
+
+	b, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	if _, err := v.inner.Upload(ctx, key+volumeIndexExt, bytes.NewReader(b), nil); err != nil {
+		return "", fmt.Errorf("volume index upload failed: %w", err)
+	}
+
+	fmt.Printf("Split into %d volume(s)\n", len(index.Volumes))
+	return key, nil
+}
+
+// spool copies up to maxSize bytes of r into a temp file rewound for reading.
+func (v *volumeRepo) spool(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "ez-snapshot-volume-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.CopyN(f, r, v.maxSize)
+	if err != nil && err != io.EOF {
+		removeSpool(f)
+		return nil, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		removeSpool(f)
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+func removeSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func (v *volumeRepo) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	paths, err := v.paths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath, ok := paths[key+volumeIndexExt]
+	if !ok {
+		return v.inner.Download(ctx, key)
+	}
+
+	index, err := v.readIndex(ctx, indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volumeReader{ctx: ctx, repo: v, paths: paths, volumes: index.Volumes, size: index.Size}, nil
+}
+
+// Stat returns split backups with the size and metadata held by their
+// index.
+func (v *volumeRepo) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	b, err := v.inner.Stat(ctx, key+volumeIndexExt)
+	if errors.Is(err, ErrNotFound) {
+		return v.inner.Stat(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := v.readIndex(ctx, b.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.Path = strings.TrimSuffix(b.Path, volumeIndexExt)
+	b.Name = strings.TrimSuffix(b.Name, volumeIndexExt)
+	b.Size = index.Size
+	b.Metadata = index.Metadata
+	return b, nil
+}
+
+func (v *volumeRepo) readIndex(ctx context.Context, path string) (*volumeIndex, error) {
+	r, err := v.inner.Download(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var index volumeIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid volume index %s: %w", path, err)
+	}
+	return &index, nil
+}
+
+// paths maps the path of every stored object by its path and by its name,
+// volumes are listed by name in the index.
+func (v *volumeRepo) paths(ctx context.Context) (map[string]string, error) {
+	objects, err := v.inner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string, 2*len(objects))
+	for _, o := range objects {
+		paths[o.Name] = o.Path
+		paths[o.Path] = o.Path
+	}
+	return paths, nil
+}
+
+func (v *volumeRepo) Delete(ctx context.Context, key string) error {
+	paths, err := v.paths(ctx)
+	if err != nil {
+		return err
+	}
+
+	indexPath, ok := paths[key+volumeIndexExt]
+	if !ok {
+		return v.inner.Delete(ctx, key)
+	}
+
+	index, err := v.readIndex(ctx, indexPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range index.Volumes {
+		if p, ok := paths[name]; ok {
+			if err := v.inner.Delete(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+	return v.inner.Delete(ctx, indexPath)
+}
+
+// List shows every split backup once, with the total size of its volumes.
+func (v *volumeRepo) List(ctx context.Context) ([]*entity.Backup, error) {
+	objects, err := v.inner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	split := make(map[string]*entity.Backup)
+	for _, o := range objects {
+		if strings.HasSuffix(o.Name, volumeIndexExt) {
+			o.Name = strings.TrimSuffix(o.Name, volumeIndexExt)
+			o.Path = strings.TrimSuffix(o.Path, volumeIndexExt)
+			o.Size = 0
+			split[o.Name] = o
+		}
+	}
+
+	backups := make([]*entity.Backup, 0, len(objects))
+	for _, o := range objects {
+		if b, ok := split[volumeOf(o.Name)]; ok {
+			b.Size += o.Size
+			continue
+		}
+		backups = append(backups, o)
+	}
+	return backups, nil
+}
+
+// volumeOf returns the backup name of a volume, eg : db.tar.gz for
+// db.tar.gz.001, or an empty string.
+func volumeOf(name string) string {
+	i := strings.LastIndex(name, ".")
+	if i < 0 || len(name)-i-1 < 3 {
+		return ""
+	}
+	if _, err := strconv.Atoi(name[i+1:]); err != nil {
+		return ""
+	}
+	return name[:i]
+}
+
+// volumeReader downloads the volumes of a backup one at a time.
+type volumeReader struct {
+	ctx     context.Context
+	repo    *volumeRepo
+	paths   map[string]string
+	volumes []string
+	size    int64
+	read    int64
+	current io.ReadCloser
+}
+
+func (vr *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if vr.current == nil {
+			if len(vr.volumes) == 0 {
+				if vr.read != vr.size {
+					return 0, fmt.Errorf("split backup is truncated: read %d of %d bytes", vr.read, vr.size)
+				}
+				return 0, io.EOF
+			}
+			p, ok := vr.paths[vr.volumes[0]]
+			if !ok {
+				return 0, fmt.Errorf("missing volume %s", vr.volumes[0])
+			}
+			body, err := vr.repo.inner.Download(vr.ctx, p)
+			if err != nil {
+				return 0, err
+			}
+			vr.current = body
+			vr.volumes = vr.volumes[1:]
+		}
+
+		n, err := vr.current.Read(p)
+		vr.read += int64(n)
+		if err == io.EOF {
+			vr.current.Close()
+			vr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (vr *volumeReader) Close() error {
+	if vr.current != nil {
+		return vr.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// davAuth authorizes WebDAV requests with basic auth until the server asks
+// for digest auth, the last digest challenge is then answered up front so
+// streamed bodies never have to be replayed.
+type davAuth struct {
+	username string
+	password string
+
+	mu     sync.Mutex
+	digest map[string]string // challenge parameters
+	nc     int
+}
+
+// authorize sets the Authorization header of req, if credentials are set.
+func (a *davAuth) authorize(req *http.Request) {
+	if a.username == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.digest == nil {
+		req.SetBasicAuth(a.username, a.password)
+		return
+	}
+
+	a.nc++
+	req.Header.Set("Authorization", a.digestResponse(req, a.nc))
+}
+
+// challenge records the digest challenge of a 401 response and reports
+// whether the request is worth retrying.
+func (a *davAuth) challenge(resp *http.Response) bool {
+	if a.username == "" {
+		return false
+	}
+
+	for _, h := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(h, " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		c := parseChallenge(params)
+		if algo := strings.ToUpper(c["algorithm"]); algo != "" && algo != "MD5" && algo != "SHA-256" {
+			continue
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		// a stale nonce is retried, otherwise the credentials were refused
+		retry := a.digest == nil || strings.EqualFold(c["stale"], "true")
+		a.digest, a.nc = c, 0
+		return retry
+	}
+	return false
+}
+
+// digestResponse answers the recorded challenge (RFC 7616) for req.
+func (a *davAuth) digestResponse(req *http.Request, nc int) string {
+	c := a.digest
+
+	var h func() hash.Hash = md5.New
+	if strings.EqualFold(c["algorithm"], "SHA-256") {
+		h = sha256.New
+	}
+	sum := func(s string) string {
+		d := h()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	uri := req.URL.RequestURI()
+	ha1 := sum(a.username + ":" + c["realm"] + ":" + a.password)
+	ha2 := sum(req.Method + ":" + uri)
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, a.username),
+		fmt.Sprintf(`realm="%s"`, c["realm"]),
+		fmt.Sprintf(`nonce="%s"`, c["nonce"]),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+
+	if c["qop"] != "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		cnonce := hex.EncodeToString(b)
+		count := fmt.Sprintf("%08x", nc)
+		fields = append(fields,
+			"qop=auth",
+			"nc="+count,
+			fmt.Sprintf(`cnonce="%s"`, cnonce),
+			fmt.Sprintf(`response="%s"`, sum(ha1+":"+c["nonce"]+":"+count+":"+cnonce+":auth:"+ha2)),
+		)
+	} else {
+		fields = append(fields, fmt.Sprintf(`response="%s"`, sum(ha1+":"+c["nonce"]+":"+ha2)))
+	}
+
+	if c["algorithm"] != "" {
+		fields = append(fields, "algorithm="+c["algorithm"])
+	}
+	if c["opaque"] != "" {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, c["opaque"]))
+	}
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+// parseChallenge parses the comma separated key=value parameters of a
+// challenge, values may be quoted.
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(s, ", "), "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, s = rest[1:end+1], rest[end+2:]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"ez-snapshot/internal/config"
+	"ez-snapshot/internal/entity"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const davPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getcontenttype/><d:getlastmodified/></d:prop></d:propfind>`
+
+// webdavImpl stores backups in a WebDAV collection (Nextcloud, ownCloud,
+// NAS appliances, ...).
+type webdavImpl struct {
+	base   *url.URL
+	auth   *davAuth
+	client *http.Client
+}
+
+func newWebDAVImpl(cfg *config.WebDAVConfig) (*webdavImpl, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid webdav.url: %s", cfg.URL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+
+	return &webdavImpl{
+		base:   u,
+		auth:   &davAuth{username: cfg.Username, password: cfg.Password},
+		client: &http.Client{},
+	}, nil
+}
+
+func (w *webdavImpl) fileURL(key string) string {
+	return w.base.String() + url.PathEscape(key)
+}
+
+// send authorizes and sends a request without retrying it.
+func (w *webdavImpl) send(req *http.Request) (*http.Response, error) {
+	w.auth.authorize(req)
+	return w.client.Do(req)
+}
+
+// do sends a request without a body or with a small one, answering an
+// authentication challenge if needed. Responses with an unexpected status
+// are turned into errors.
+func (w *webdavImpl) do(ctx context.Context, method, target string, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := w.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && w.auth.challenge(resp) {
+			resp.Body.Close()
+			continue
+		}
+		if !davExpected(resp.StatusCode, expected) {
+			defer resp.Body.Close()
+			return nil, davError(method, resp)
+		}
+		return resp, nil
+	}
+}
+
+func davExpected(status int, expected []int) bool {
+	if len(expected) == 0 {
+		return status/100 == 2
+	}
+	for _, e := range expected {
+		if status == e {
+			return true
+		}
+	}
+	return false
+}
+
+func davError(method string, resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(b)); msg != "" && !strings.HasPrefix(msg, "<") {
+		return withStatus(resp, fmt.Errorf("webdav %s failed: %s: %s", method, resp.Status, msg))
+	}
+	return withStatus(resp, fmt.Errorf("webdav %s failed: %s", method, resp.Status))
+}
+
+// Upload streams the backup to a hidden temp name and moves it in place once
+// complete, so a partial upload is never listed. Files have no metadata, it
+// is kept in a sidecar.
+func (w *webdavImpl) Upload(ctx context.Context, key string, r io.Reader, _ Metadata) (string, error) {
+	// also answers the authentication challenge, the streamed PUT can't be
+	// replayed
+	if err := w.mkcol(ctx); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Begin upload to %s\n", w.fileURL(key))
+
+	tmp := w.fileURL(fmt.Sprintf(".%s.tmp-%d", key, time.Now().UnixNano()))
+	pc := &progressCounter{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, tmp, io.TeeReader(r, pc))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := w.send(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return "", davError(http.MethodPut, resp)
+	}
+	resp.Body.Close()
+	fmt.Printf("\rUploaded %d bytes\n", pc.n)
+
+	h := http.Header{}
+	h.Set("Destination", w.fileURL(key))
+	h.Set("Overwrite", "T")
+	if resp, err = w.do(ctx, "MOVE", tmp, h, nil); err != nil {
+		if resp, derr := w.do(context.WithoutCancel(ctx), http.MethodDelete, tmp, nil, nil); derr == nil {
+			resp.Body.Close()
+		}
+		return "", err
+	}
+	resp.Body.Close()
+
+	fmt.Println("✅ Backup has been uploaded")
+	return key, nil
+}
+
+// mkcol creates the collection backups are stored in, a 405 means it already
+// exists.
+func (w *webdavImpl) mkcol(ctx context.Context) error {
+	resp, err := w.do(ctx, "MKCOL", w.base.String(), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (w *webdavImpl) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := w.do(ctx, http.MethodGet, w.fileURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (w *webdavImpl) Delete(ctx context.Context, key string) error {
+	resp, err := w.do(ctx, http.MethodDelete, w.fileURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (w *webdavImpl) Stat(ctx context.Context, key string) (*entity.Backup, error) {
+	responses, err := w.propfind(ctx, w.fileURL(key), "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	b := responses[0].backup()
+	b.Path, b.Name = key, key
+	return b, nil
+}
+
+// List lists the files of the collection with a depth 1 PROPFIND.
+func (w *webdavImpl) List(ctx context.Context) ([]*entity.Backup, error) {
+	responses, err := w.propfind(ctx, w.base.String(), "1")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []*entity.Backup{}
+	for _, r := range responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("invalid href in propfind response: %s", r.Href)
+		}
+		name := path.Base(href.Path)
+		if strings.HasSuffix(href.Path, "/") || strings.HasPrefix(name, ".") {
+			// the collection itself, sub collections and temp files
+			continue
+		}
+
+		b := r.backup()
+		b.Path, b.Name = name, name
+		if !b.IsDir {
+			backups = append(backups, b)
+		}
+	}
+	return backups, nil
+}
+
+type davResponse struct {
+	Href     string `xml:"DAV: href"`
+	Propstat []struct {
+		Status string `xml:"DAV: status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"DAV: collection"`
+			} `xml:"DAV: resourcetype"`
+			ContentLength int64  `xml:"DAV: getcontentlength"`
+			ContentType   string `xml:"DAV: getcontenttype"`
+			LastModified  string `xml:"DAV: getlastmodified"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+// propfind returns the responses of a PROPFIND, nil when target doesn't
+// exist.
+func (w *webdavImpl) propfind(ctx context.Context, target, depth string) ([]davResponse, error) {
+	h := http.Header{}
+	h.Set("Depth", depth)
+	h.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := w.do(ctx, "PROPFIND", target, h, []byte(davPropfind), http.StatusMultiStatus, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var result struct {
+		Responses []davResponse `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid propfind response: %w", err)
+	}
+	return result.Responses, nil
+}
+
+// backup returns the properties of a response, Path and Name are left to
+// the caller.
+func (r davResponse) backup() *entity.Backup {
+	b := &entity.Backup{}
+	for _, ps := range r.Propstat {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		if ps.Prop.ResourceType.Collection != nil {
+			b.IsDir = true
+		}
+		b.Size = ps.Prop.ContentLength
+		b.MimeType = ps.Prop.ContentType
+		b.ModTime, _ = http.ParseTime(ps.Prop.LastModified)
+	}
+	return b
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"ez-snapshot/internal/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+const (
+	davTestRealm = "ez-snapshot"
+	davTestNonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+)
+
+// digestOnly answers requests without a valid MD5 digest (RFC 7616) for
+// username and password with a challenge.
+func digestOnly(next http.Handler, username, password string) http.Handler {
+	sum := func(s string) string {
+		d := md5.Sum([]byte(s))
+		return hex.EncodeToString(d[:])
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		c := parseChallenge(params)
+		ha1 := sum(username + ":" + davTestRealm + ":" + password)
+		ha2 := sum(r.Method + ":" + r.URL.RequestURI())
+		expected := sum(ha1 + ":" + davTestNonce + ":" + c["nc"] + ":" + c["cnonce"] + ":auth:" + ha2)
+
+		if scheme != "Digest" || c["username"] != username || c["nonce"] != davTestNonce ||
+			c["uri"] != r.URL.RequestURI() || c["response"] != expected {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", algorithm=MD5`, davTestRealm, davTestNonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestWebDAVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string // server side, digest auth when set
+		password string
+		client   config.WebDAVConfig
+		wantErr  bool
+	}{
+		{name: "anonymous"},
+		{
+			name:     "digest",
+			username: "backup",
+			password: "s3cret",
+			client:   config.WebDAVConfig{Username: "backup", Password: "s3cret"},
+		},
+		{
+			name:     "digest wrong password",
+			username: "backup",
+			password: "s3cret",
+			client:   config.WebDAVConfig{Username: "backup", Password: "wrong"},
+			wantErr:  true,
+		},
+		{
+			name:     "digest no credentials",
+			username: "backup",
+			password: "s3cret",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Handler = &webdav.Handler{
+				FileSystem: webdav.NewMemFS(),
+				LockSystem: webdav.NewMemLS(),
+			}
+			if tt.username != "" {
+				h = digestOnly(h, tt.username, tt.password)
+			}
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			cfg := tt.client
+			cfg.URL = srv.URL + "/backups"
+			w, err := newWebDAVImpl(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			const key, content = "db-20261019.sql.gz", "backup content"
+			_, err = w.Upload(ctx, key, strings.NewReader(content), nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Upload succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+
+			backups, err := w.List(ctx)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(backups) != 1 || backups[0].Name != key || backups[0].Size != int64(len(content)) {
+				t.Fatalf("List = %+v, want %s of %d bytes", backups, key, len(content))
+			}
+
+			b, err := w.Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if b.Path != key || b.Size != int64(len(content)) || b.ModTime.IsZero() {
+				t.Fatalf("Stat = %+v", b)
+			}
+
+			rc, err := w.Download(ctx, key)
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if string(got) != content {
+				t.Fatalf("Download = %q, want %q", got, content)
+			}
+
+			if err := w.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := w.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+			}
+			if backups, err := w.List(ctx); err != nil || len(backups) != 0 {
+				t.Fatalf("List after Delete = %+v, %v", backups, err)
+			}
+		})
+	}
+}
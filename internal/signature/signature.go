@@ -0,0 +1,136 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ext is the extension of the sidecar object holding the signature of a
+// backup checksum.
+const Ext = ".sig"
+
+// Status is the outcome of verifying a backup signature.
+type Status string
+
+const (
+	Valid    Status = "signed"
+	Unsigned Status = "unsigned"
+	Invalid  Status = "invalid signature"
+)
+
+// Policy signs backups on the backup host and verifies them against trusted
+// public keys on restore. A nil Policy disables signing and verification.
+type Policy struct {
+	signer  ed25519.PrivateKey
+	trusted []ed25519.PublicKey
+	require bool
+}
+
+// NewPolicy loads a PEM encoded (PKCS #8) ed25519 private key and PEM encoded
+// (PKIX) public keys, as written by
+//
+//	openssl genpkey -algorithm ed25519 -out signing.pem
+//	openssl pkey -in signing.pem -pubout -out signing.pub
+func NewPolicy(privateKeyFile string, trustedKeyFiles []string, require bool) (*Policy, error) {
+	p := &Policy{require: require}
+
+	if privateKeyFile != "" {
+		key, err := readPEM(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", privateKeyFile, err)
+		}
+		signer, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not an ed25519 key", privateKeyFile)
+		}
+		p.signer = signer
+	}
+
+	for _, f := range trustedKeyFiles {
+		key, err := readPEM(f)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := x509.ParsePKIXPublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %s: %w", f, err)
+		}
+		pub, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("trusted key %s is not an ed25519 key", f)
+		}
+		p.trusted = append(p.trusted, pub)
+	}
+
+	if require && len(p.trusted) == 0 {
+		return nil, errors.New("signing.require_signature needs at least one signing.trusted_keys entry")
+	}
+
+	return p, nil
+}
+
+// CanSign reports whether a signing key is configured.
+func (p *Policy) CanSign() bool {
+	return p != nil && p.signer != nil
+}
+
+// Verifies reports whether signatures are verified against trusted keys.
+func (p *Policy) Verifies() bool {
+	return p != nil && len(p.trusted) > 0
+}
+
+// Sign returns the base64 encoded signature of msg.
+func (p *Policy) Sign(msg []byte) []byte {
+	sig := ed25519.Sign(p.signer, msg)
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// Verify checks a signature written by Sign, a nil sig means the backup has
+// no signature.
+func (p *Policy) Verify(msg, sig []byte) Status {
+	if sig == nil {
+		return Unsigned
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return Invalid
+	}
+
+	for _, pub := range p.trusted {
+		if ed25519.Verify(pub, msg, raw) {
+			return Valid
+		}
+	}
+	return Invalid
+}
+
+// Check returns an error when the policy refuses a backup with status.
+func (p *Policy) Check(status Status) error {
+	if p == nil || !p.require || status == Valid {
+		return nil
+	}
+	return fmt.Errorf("backup is refused by the signature policy: %s", status)
+}
+
+func readPEM(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM encoded key", path)
+	}
+	return block.Bytes, nil
+}
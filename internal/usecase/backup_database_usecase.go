@@ -2,31 +2,79 @@ package usecase
 
 import (
 	"context"
+	"ez-snapshot/internal/entity"
 	"ez-snapshot/internal/repository/backup"
 	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
 	"os"
 )
 
 type BackupDatabaseUseCase struct {
 	backup  backup.Repository
 	storage storage.Repository
+	policy  *signature.Policy
+	tags    map[string]string // attached to the backup as metadata
 }
 
 func NewBackupDatabaseUseCase(
 	backup backup.Repository,
 	storage storage.Repository,
+	policy *signature.Policy,
+	tags map[string]string,
 ) *BackupDatabaseUseCase {
 	return &BackupDatabaseUseCase{
 		backup:  backup,
 		storage: storage,
+		policy:  policy,
+		tags:    tags,
 	}
 }
 
 func (uc *BackupDatabaseUseCase) Execute(ctx context.Context) error {
-	dumpPath, err := uc.backup.Dump(ctx)
+	if err := CheckTags(uc.tags); err != nil {
+		return err
+	}
+
+	dumpPath, manifest, err := uc.backup.Dump(ctx)
+	if err != nil {
+		return err
+	}
+	return uc.upload(ctx, dumpPath, manifest)
+}
+
+// ExecuteDifferential only backs up the tables changed since the latest full
+// backup of the database, it takes a full backup when there is none.
+func (uc *BackupDatabaseUseCase) ExecuteDifferential(ctx context.Context) error {
+	if err := CheckTags(uc.tags); err != nil {
+		return err
+	}
+
+	base, manifest, err := uc.latestFullBackup(ctx)
 	if err != nil {
 		return err
 	}
+	if base == nil {
+		fmt.Println("No full backup found, taking a full backup instead")
+		return uc.Execute(ctx)
+	}
+
+	fmt.Printf("Base backup: %s\n", base.Name)
+	dumpPath, diff, err := uc.backup.DumpDifferential(ctx, manifest, base.Name)
+	if err != nil {
+		return err
+	}
+	return uc.upload(ctx, dumpPath, diff)
+}
+
+// latestFullBackup returns the newest full backup of the database.
+func (uc *BackupDatabaseUseCase) latestFullBackup(ctx context.Context) (*entity.Backup, *entity.Manifest, error) {
+	return newestFullBackup(ctx, uc.storage, uc.backup, uc.policy, func(*entity.Backup, *entity.Manifest) bool {
+		return true
+	})
+}
+
+func (uc *BackupDatabaseUseCase) upload(ctx context.Context, dumpPath string, manifest *entity.Manifest) error {
 	f, err := os.Open(dumpPath)
 	if err != nil {
 		return err
@@ -38,10 +86,13 @@ func (uc *BackupDatabaseUseCase) Execute(ctx context.Context) error {
 		return err
 	}
 
-	_, err = uc.storage.Upload(ctx, stat.Name(), f)
-	if err != nil {
+	meta := archiveMetadata(manifest, uc.tags)
+	if _, err := uploadWithChecksum(ctx, uc.storage, stat.Name(), f, meta, uc.policy); err != nil {
 		return err
 	}
 
-	return nil
+	if manifest.IsDifferential() {
+		return nil
+	}
+	return uploadManifest(ctx, uc.storage, uc.backup, stat.Name(), f, manifest, uc.policy)
 }
@@ -0,0 +1,183 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/backup"
+	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// binlogPrefix is prepended to the name of archived binary logs, eg :
+// binlog-binlog.000042
+const binlogPrefix = "binlog-"
+
+// isBinlog reports whether name is an archived binary log rather than a backup.
+func isBinlog(name string) bool {
+	return strings.HasPrefix(name, binlogPrefix)
+}
+
+// ArchiveBinlogUseCase continuously uploads the binary logs of the server
+// next to the backups.
+type ArchiveBinlogUseCase struct {
+	backup   backup.Repository
+	storage  storage.Repository
+	policy   *signature.Policy
+	spoolDir string
+	interval time.Duration
+}
+
+func NewArchiveBinlogUseCase(
+	backup backup.Repository,
+	storage storage.Repository,
+	policy *signature.Policy,
+	spoolDir string,
+	interval time.Duration,
+) *ArchiveBinlogUseCase {
+	return &ArchiveBinlogUseCase{
+		backup:   backup,
+		storage:  storage,
+		policy:   policy,
+		spoolDir: spoolDir,
+		interval: interval,
+	}
+}
+
+// Execute streams binary logs until ctx is canceled. It resumes from the
+// last archived binary log, which is streamed again as it may be partial.
+func (uc *ArchiveBinlogUseCase) Execute(ctx context.Context) error {
+	repo, ok := uc.backup.(backup.BinlogRepository)
+	if !ok {
+		return errors.New("binary log archiving is not supported by this database")
+	}
+
+	from, err := uc.resumeFrom(ctx, repo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Streaming binary logs from %s into %s ...\n", from, uc.spoolDir)
+
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- repo.StreamBinlogs(ctx, uc.spoolDir, from)
+	}()
+
+	uploaded := make(map[string]int64)
+	ticker := time.NewTicker(uc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-streamErr:
+			// upload what has been streamed so far before leaving
+			if uerr := uc.upload(context.WithoutCancel(ctx), repo, uploaded); uerr != nil {
+				return uerr
+			}
+			if err == nil && ctx.Err() == nil {
+				err = errors.New("binary log stream stopped")
+			}
+			return err
+		case <-ticker.C:
+			if err := uc.upload(ctx, repo, uploaded); err != nil {
+				fmt.Printf("⚠️ Binary log upload failed, retrying in %s: %v\n", uc.interval, err)
+			}
+		}
+	}
+}
+
+func (uc *ArchiveBinlogUseCase) resumeFrom(ctx context.Context, repo backup.BinlogRepository) (string, error) {
+	names, err := archivedBinlogs(ctx, uc.storage)
+	if err != nil {
+		return "", err
+	}
+	if len(names) > 0 {
+		return strings.TrimPrefix(names[len(names)-1].Name, binlogPrefix), nil
+	}
+	return repo.FirstBinlog(ctx)
+}
+
+// upload uploads the binary logs that grew since their last upload. Every
+// binary log but the newest one is complete and removed once uploaded.
+func (uc *ArchiveBinlogUseCase) upload(ctx context.Context, repo backup.BinlogRepository, uploaded map[string]int64) error {
+	entries, err := os.ReadDir(uc.spoolDir)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(uc.spoolDir, e.Name())
+		complete := i < len(entries)-1
+
+		if uploaded[e.Name()] != info.Size() {
+			if err := uc.uploadBinlog(ctx, repo, path); err != nil {
+				return err
+			}
+			uploaded[e.Name()] = info.Size()
+			fmt.Printf("✅ Archived %s (%d bytes)\n", e.Name(), info.Size())
+		}
+
+		if complete {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			delete(uploaded, e.Name())
+		}
+	}
+	return nil
+}
+
+// uploadBinlog uploads a binary log with the time of its last event, which
+// tells point-in-time recovery which binary log a target falls into.
+func (uc *ArchiveBinlogUseCase) uploadBinlog(ctx context.Context, repo backup.BinlogRepository, path string) error {
+	meta := storage.Metadata{}
+	if last, err := repo.LastBinlogEvent(ctx, path); err != nil {
+		fmt.Printf("⚠️ Failed to read the last event of %s: %v\n", filepath.Base(path), err)
+	} else if !last.IsZero() {
+		meta[metaLastEvent] = last.UTC().Format(time.RFC3339)
+	}
+
+	packed, err := repo.PackBinlog(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(packed)
+
+	f, err := os.Open(packed)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = uploadWithChecksum(ctx, uc.storage, binlogPrefix+filepath.Base(path), f, meta, uc.policy)
+	return err
+}
+
+// archivedBinlogs lists the archived binary logs sorted by name.
+func archivedBinlogs(ctx context.Context, s storage.Repository) ([]*entity.Backup, error) {
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	binlogs := make([]*entity.Backup, 0, len(list))
+	for _, b := range list {
+		if isBinlog(b.Name) && !isSidecar(b.Name) {
+			binlogs = append(binlogs, b)
+		}
+	}
+	sort.Slice(binlogs, func(i, j int) bool {
+		return binlogs[i].Name < binlogs[j].Name
+	})
+	return binlogs, nil
+}
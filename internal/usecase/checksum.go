@@ -0,0 +1,222 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// checksumExt is the extension of the sidecar object holding the SHA-256 of
+// a backup, in the format written by sha256sum.
+const checksumExt = ".sha256"
+
+// sidecarExts are the extensions of the objects stored next to a backup.
+var sidecarExts = []string{checksumExt, signature.Ext, manifestExt, manifestExt + signature.Ext}
+
+// isSidecar reports whether name is a sidecar object rather than a backup.
+func isSidecar(name string) bool {
+	return strings.HasSuffix(name, checksumExt) || strings.HasSuffix(name, signature.Ext) ||
+		strings.HasSuffix(name, manifestExt)
+}
+
+// uploadWithChecksum uploads the file with meta and its checksum attached,
+// followed by its checksum sidecar, and by the signature of the checksum
+// sidecar when a signing key is configured.
+func uploadWithChecksum(ctx context.Context, s storage.Repository, name string, f *os.File, meta storage.Metadata, policy *signature.Policy) (string, error) {
+	sum, err := fileChecksum(f)
+	if err != nil {
+		return "", err
+	}
+
+	if meta == nil {
+		meta = storage.Metadata{}
+	}
+	meta[metaSHA256] = sum
+
+	key, err := s.Upload(ctx, name, f, meta)
+	if err != nil {
+		return "", err
+	}
+
+	sidecar := []byte(fmt.Sprintf("%s  %s\n", sum, name))
+	if _, err := s.Upload(ctx, name+checksumExt, bytes.NewReader(sidecar), nil); err != nil {
+		return "", fmt.Errorf("checksum upload failed: %w", err)
+	}
+
+	if policy.CanSign() {
+		if _, err := s.Upload(ctx, name+signature.Ext, bytes.NewReader(policy.Sign(sidecar)), nil); err != nil {
+			return "", fmt.Errorf("signature upload failed: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+// fileChecksum hashes f and rewinds it.
+func fileChecksum(f *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadVerified downloads a backup into a local staging file, compares it
+// with its checksum sidecar and enforces the signature policy. The targets of
+// a replicated storage are tried in turn until one holds a valid copy. The
+// caller must close and remove the file.
+func downloadVerified(ctx context.Context, s storage.Repository, key string, policy *signature.Policy) (*os.File, error) {
+	targets := storage.Targets(s)
+	if len(targets) == 1 {
+		return downloadVerifiedFrom(ctx, s, key, policy)
+	}
+
+	var errs []error
+	for i, t := range targets {
+		f, err := downloadVerifiedFrom(ctx, t, key, policy)
+		if err == nil {
+			return f, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(targets)-1 {
+			fmt.Printf("⚠️ %s from %s is not usable (%v), trying %s\n", key, t.Name, err, targets[i+1].Name)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// downloadVerifiedFrom downloads and verifies a backup and its sidecars from
+// a single storage.
+func downloadVerifiedFrom(ctx context.Context, s storage.Repository, key string, policy *signature.Policy) (*os.File, error) {
+	body, err := s.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp("", "ez-snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), body); err != nil {
+		removeFile(f)
+		return nil, fmt.Errorf("download interrupted: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		removeFile(f)
+		return nil, err
+	}
+
+	sidecar, err := readSidecar(ctx, s, key+checksumExt)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		removeFile(f)
+		return nil, fmt.Errorf("can't read the checksum of %s: %w", key, err)
+	}
+	if err != nil {
+		if err := policy.Check(signature.Unsigned); err != nil {
+			removeFile(f)
+			return nil, err
+		}
+		fmt.Printf("⚠️ No checksum found for %s (%v), skipping checksum verification\n", key, err)
+		return f, nil
+	}
+
+	expected, err := parseChecksum(sidecar, key)
+	if err != nil {
+		removeFile(f)
+		return nil, err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+		removeFile(f)
+		return nil, fmt.Errorf("checksum mismatch: expected %s, got %s", expected, sum)
+	}
+
+	if policy.Verifies() {
+		status, err := verifySignature(ctx, s, key, sidecar, policy)
+		if err != nil {
+			removeFile(f)
+			return nil, err
+		}
+		if err := policy.Check(status); err != nil {
+			removeFile(f)
+			return nil, err
+		}
+		if status == signature.Valid {
+			fmt.Println("✅ Signature is valid")
+		} else {
+			fmt.Printf("⚠️ Backup %s is %s\n", key, status)
+		}
+	}
+
+	return f, nil
+}
+
+// verifySignature verifies the signature sidecar of key over the checksum
+// sidecar content. A missing signature is Unsigned, one that can't be read is
+// an error.
+func verifySignature(ctx context.Context, s storage.Repository, key string, sidecar []byte, policy *signature.Policy) (signature.Status, error) {
+	sig, err := readSidecar(ctx, s, key+signature.Ext)
+	if errors.Is(err, storage.ErrNotFound) {
+		return policy.Verify(sidecar, nil), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't read the signature of %s: %w", key, err)
+	}
+	return policy.Verify(sidecar, sig), nil
+}
+
+// readSidecar downloads a small sidecar object, a missing one is reported as
+// storage.ErrNotFound.
+func readSidecar(ctx context.Context, s storage.Repository, key string) ([]byte, error) {
+	r, err := s.Download(ctx, key)
+	if err != nil {
+		// download errors differ between storages, Stat tells whether the
+		// sidecar exists
+		if _, serr := s.Stat(ctx, key); errors.Is(serr, storage.ErrNotFound) {
+			return nil, serr
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(io.LimitReader(r, 4096))
+}
+
+// parseChecksum extracts the hex SHA-256 from the checksum sidecar of key.
+// The file name it was written for must match, so a signed sidecar can't be
+// replayed next to a backup renamed to another key.
+func parseChecksum(sidecar []byte, key string) (string, error) {
+	fields := strings.Fields(string(sidecar))
+	if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file")
+	}
+
+	// sha256sum marks files hashed in binary mode with a *
+	if name := strings.TrimPrefix(fields[1], "*"); name != path.Base(key) {
+		return "", fmt.Errorf("checksum file is for %s, not %s", name, path.Base(key))
+	}
+	return fields[0], nil
+}
+
+func removeFile(f *os.File) {
+	f.Close()
+	_ = os.Remove(f.Name())
+}
@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memStorage keeps objects in memory, downloads of the keys in failing
+// return their error.
+type memStorage struct {
+	objects map[string][]byte
+	failing map[string]error
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: map[string][]byte{}, failing: map[string]error{}}
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, r io.Reader, _ storage.Metadata) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	m.objects[key] = b
+	return key, nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string) (io.ReadCloser, error) {
+	if err := m.failing[key]; err != nil {
+		return nil, err
+	}
+	b, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no such object: %s", key)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStorage) Stat(_ context.Context, key string) (*entity.Backup, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, key)
+	}
+	return &entity.Backup{Path: key, Name: key, Size: int64(len(b))}, nil
+}
+
+func (m *memStorage) Delete(_ context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStorage) List(context.Context) ([]*entity.Backup, error) {
+	return nil, nil
+}
+
+// newTestPolicy returns a policy signing with a new key and trusting it.
+func newTestPolicy(t *testing.T, require bool) *signature.Policy {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	privFile, pubFile := filepath.Join(dir, "signing.pem"), filepath.Join(dir, "signing.pub")
+	if err := os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := signature.NewPolicy(privFile, []string{pubFile}, require)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestDownloadVerifiedSidecars(t *testing.T) {
+	const key = "db-20261019.sql.gz"
+	errForbidden := errors.New("403 Forbidden")
+
+	tests := []struct {
+		name     string
+		optional bool // signatures aren't required
+		edit     func(m *memStorage)
+		wantErr  string
+	}{
+		{name: "verified"},
+		{
+			name:    "corrupted",
+			edit:    func(m *memStorage) { m.objects[key] = []byte("tampered") },
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "checksum unreadable",
+			edit:    func(m *memStorage) { m.failing[key+checksumExt] = errForbidden },
+			wantErr: "can't read the checksum",
+		},
+		{
+			name:    "corrupted and checksum unreadable",
+			edit:    func(m *memStorage) { m.objects[key] = []byte("tampered"); m.failing[key+checksumExt] = errForbidden },
+			wantErr: "can't read the checksum",
+		},
+		{
+			name:    "checksum missing",
+			edit:    func(m *memStorage) { delete(m.objects, key+checksumExt) },
+			wantErr: "refused by the signature policy: unsigned",
+		},
+		{
+			name:     "checksum unreadable, signatures optional",
+			optional: true,
+			edit:     func(m *memStorage) { m.objects[key] = []byte("tampered"); m.failing[key+checksumExt] = errForbidden },
+			wantErr:  "can't read the checksum",
+		},
+		{
+			name:     "checksum missing, signatures optional",
+			optional: true,
+			edit:     func(m *memStorage) { delete(m.objects, key+checksumExt) },
+		},
+		{
+			name:    "signature unreadable",
+			edit:    func(m *memStorage) { m.failing[key+signature.Ext] = errForbidden },
+			wantErr: "can't read the signature",
+		},
+		{
+			name:    "signature missing",
+			edit:    func(m *memStorage) { delete(m.objects, key+signature.Ext) },
+			wantErr: "refused by the signature policy: unsigned",
+		},
+	}
+
+	required, optional := newTestPolicy(t, true), newTestPolicy(t, false)
+	src := filepath.Join(t.TempDir(), key)
+	if err := os.WriteFile(src, []byte("backup content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			policy := required
+			if tt.optional {
+				policy = optional
+			}
+			m := newMemStorage()
+			f, err := os.Open(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := uploadWithChecksum(ctx, m, key, f, nil, policy); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				tt.edit(m)
+			}
+
+			out, err := downloadVerified(ctx, m, key, policy)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("downloadVerified: %v", err)
+				}
+				removeFile(out)
+				return
+			}
+			if err == nil {
+				removeFile(out)
+				t.Fatalf("downloadVerified succeeded, want %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("downloadVerified error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/storage"
+	"fmt"
+	"path"
+)
+
+// CopyBackupsUseCase copies backups, with their checksum and signature
+// sidecars, from one storage to another.
+type CopyBackupsUseCase struct {
+	src storage.Repository
+	dst storage.Repository
+}
+
+func NewCopyBackupsUseCase(src, dst storage.Repository) *CopyBackupsUseCase {
+	return &CopyBackupsUseCase{
+		src: src,
+		dst: dst,
+	}
+}
+
+// Select lists the backups and binary logs of the source whose name matches
+// pattern (path.Match syntax, eg : db_202610*), every one when it's empty.
+func (uc *CopyBackupsUseCase) Select(ctx context.Context, pattern string) ([]*entity.Backup, error) {
+	list, err := uc.src.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := filterBackups(list, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		describeBackup(b)
+	}
+	return backups, nil
+}
+
+// Execute copies the backups the destination doesn't hold yet and returns how
+// many were copied.
+func (uc *CopyBackupsUseCase) Execute(ctx context.Context, backups []*entity.Backup) (int, error) {
+	srcList, err := uc.src.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	dstList, err := uc.dst.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	srcObjects := make(map[string]*entity.Backup, len(srcList))
+	for _, o := range srcList {
+		srcObjects[o.Name] = o
+	}
+	dstObjects := make(map[string]bool, len(dstList))
+	for _, o := range dstList {
+		dstObjects[o.Name] = true
+	}
+
+	copied := 0
+	for _, b := range backups {
+		if dstObjects[b.Name] {
+			fmt.Printf("%s is already in the destination, skipping\n", b.Name)
+		} else {
+			if err := copyObject(ctx, uc.src, uc.dst, b); err != nil {
+				return copied, fmt.Errorf("copy of %s failed: %w", b.Name, err)
+			}
+			copied++
+		}
+
+		// sidecars missing from the destination are copied even when the
+		// backup is already there, eg : after an interrupted copy
+		for _, ext := range sidecarExts {
+			sidecar, ok := srcObjects[b.Name+ext]
+			if !ok || dstObjects[sidecar.Name] {
+				continue
+			}
+			if err := copyObject(ctx, uc.src, uc.dst, sidecar); err != nil {
+				return copied, fmt.Errorf("copy of %s failed: %w", sidecar.Name, err)
+			}
+		}
+	}
+	return copied, nil
+}
+
+// SyncBackupsUseCase makes a storage hold every backup of another one,
+// optionally removing the backups the source doesn't hold anymore.
+type SyncBackupsUseCase struct {
+	copy  *CopyBackupsUseCase
+	prune bool
+}
+
+func NewSyncBackupsUseCase(src, dst storage.Repository, prune bool) *SyncBackupsUseCase {
+	return &SyncBackupsUseCase{
+		copy:  NewCopyBackupsUseCase(src, dst),
+		prune: prune,
+	}
+}
+
+// Execute syncs the backups whose name matches pattern, every one when it's
+// empty. It returns how many backups were copied and removed.
+func (uc *SyncBackupsUseCase) Execute(ctx context.Context, pattern string) (copied, removed int, err error) {
+	backups, err := uc.copy.Select(ctx, pattern)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if copied, err = uc.copy.Execute(ctx, backups); err != nil {
+		return copied, 0, err
+	}
+	if !uc.prune {
+		return copied, 0, nil
+	}
+
+	// an empty or unreachable source would otherwise wipe the destination
+	if len(backups) == 0 {
+		return copied, 0, fmt.Errorf("the source holds no backup to sync, refusing to prune the destination")
+	}
+
+	removed, err = uc.pruneDestination(ctx, pattern, backups)
+	return copied, removed, err
+}
+
+// pruneDestination removes the backups matching pattern that are not in
+// backups, with their sidecars.
+func (uc *SyncBackupsUseCase) pruneDestination(ctx context.Context, pattern string, backups []*entity.Backup) (int, error) {
+	dstList, err := uc.copy.dst.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stale, err := filterBackups(dstList, pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	keep := make(map[string]bool, len(backups))
+	for _, b := range backups {
+		keep[b.Name] = true
+	}
+	dstObjects := make(map[string]*entity.Backup, len(dstList))
+	for _, o := range dstList {
+		dstObjects[o.Name] = o
+	}
+
+	removed := 0
+	for _, b := range stale {
+		if keep[b.Name] {
+			continue
+		}
+
+		fmt.Printf("Removing %s from the destination\n", b.Name)
+		for _, ext := range sidecarExts {
+			if sidecar, ok := dstObjects[b.Name+ext]; ok {
+				if err := uc.copy.dst.Delete(ctx, sidecar.Path); err != nil {
+					return removed, fmt.Errorf("delete of %s failed: %w", sidecar.Name, err)
+				}
+			}
+		}
+		if err := uc.copy.dst.Delete(ctx, b.Path); err != nil {
+			return removed, fmt.Errorf("delete of %s failed: %w", b.Name, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// filterBackups returns the objects of list that are not sidecars and whose
+// name matches pattern, every one when it's empty.
+func filterBackups(list []*entity.Backup, pattern string) ([]*entity.Backup, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	backups := make([]*entity.Backup, 0, len(list))
+	for _, b := range list {
+		if isSidecar(b.Name) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, b.Name); pattern != "" && !ok {
+			continue
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// copyObject copies an object server-side when the source storage supports
+// it, and streams it from the source to the destination otherwise. The
+// metadata of backups is carried over.
+func copyObject(ctx context.Context, src, dst storage.Repository, o *entity.Backup) error {
+	if c, ok := src.(storage.Copier); ok {
+		err := c.Copy(ctx, o.Path, dst, o.Name)
+		if !errors.Is(err, storage.ErrCopyNotSupported) {
+			return err
+		}
+	}
+
+	// listings don't return the metadata of every storage
+	meta := o.Metadata
+	if meta == nil && !isSidecar(o.Name) {
+		stat, err := src.Stat(ctx, o.Path)
+		if err != nil {
+			return err
+		}
+		meta = stat.Metadata
+	}
+
+	body, err := src.Download(ctx, o.Path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = dst.Upload(ctx, o.Name, body, meta)
+	return err
+}
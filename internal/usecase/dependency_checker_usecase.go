@@ -5,6 +5,7 @@ import (
 	"ez-snapshot/internal/repository/storage"
 	"fmt"
 	"os/exec"
+	"slices"
 	"strings"
 )
 
@@ -12,19 +13,27 @@ import (
 type DependencyChecker struct {
 	Dependencies []string
 	Storage      storage.Repository
+	StorageTypes []storage.StorageType
 }
 
-// NewDependencyChecker returns a checker for mysql + rclone.
+// NewDependencyChecker returns a checker for mysql, and rclone when backups
+// are stored through rclone.
 func NewDependencyChecker(
 	s storage.Repository,
+	storageTypes []storage.StorageType,
 ) *DependencyChecker {
+	dependencies := []string{
+		"mysql",     // MySQL client
+		"mysqldump", // for backup
+	}
+	if slices.Contains(storageTypes, storage.Rclone) {
+		dependencies = append(dependencies, "rclone") // for remote storage
+	}
+
 	return &DependencyChecker{
-		Dependencies: []string{
-			"mysql",     // MySQL client
-			"mysqldump", // for backup
-			"rclone",    // for remote storage
-		},
-		Storage: s,
+		Dependencies: dependencies,
+		Storage:      s,
+		StorageTypes: storageTypes,
 	}
 }
 
@@ -38,11 +47,29 @@ func (dc *DependencyChecker) Check() error {
 		}
 	}
 
+	if len(dc.StorageTypes) != 1 || dc.StorageTypes[0] != storage.Rclone {
+		var names []string
+		for _, t := range dc.StorageTypes {
+			if !slices.Contains(names, string(t)) {
+				names = append(names, string(t))
+			}
+		}
+		storageName := strings.Join(names, ", ")
+
+		fmt.Printf("Checking %s storage...\n", storageName)
+		if _, err := dc.Storage.List(context.Background()); err != nil {
+			return fmt.Errorf("%s storage is not reachable: %w", storageName, err)
+		}
+		fmt.Printf("✅ %s storage is reachable\n", storageName)
+		return nil
+	}
+
 	fmt.Println("Checking rclone connectivity...")
 
 	_, err := dc.Storage.List(context.Background())
 	if err != nil {
-		return fmt.Errorf("rclone rc API is not running, run it first using rclone rcd --rc-no-auth --rc-addr=:5572\n")
+		return fmt.Errorf("rclone rc API is not reachable (%w), run it first using "+
+			"rclone rcd --rc-addr=localhost:5572 --rc-user=<rclone.username> --rc-pass=<rclone.password>", err)
 	}
 
 	fmt.Println("✅ rclone server is running ")
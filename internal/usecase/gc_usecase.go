@@ -0,0 +1,28 @@
+package usecase
+
+import (
+	"context"
+	"ez-snapshot/internal/repository/storage"
+	"fmt"
+)
+
+// GarbageCollectUseCase removes objects no backup refers to anymore, eg :
+// chunks of deleted backups in the chunked layout.
+type GarbageCollectUseCase struct {
+	storage storage.Repository
+}
+
+func NewGarbageCollectUseCase(storage storage.Repository) *GarbageCollectUseCase {
+	return &GarbageCollectUseCase{
+		storage: storage,
+	}
+}
+
+func (uc *GarbageCollectUseCase) Execute(ctx context.Context) (int, error) {
+	gc, ok := uc.storage.(storage.GarbageCollector)
+	if !ok {
+		return 0, fmt.Errorf("the configured storage layout has nothing to collect")
+	}
+
+	return gc.GC(ctx)
+}
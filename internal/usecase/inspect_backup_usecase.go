@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/backup"
+	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InspectBackupUseCase reads the manifest of a stored backup.
+type InspectBackupUseCase struct {
+	backup  backup.Repository
+	storage storage.Repository
+}
+
+func NewInspectBackupUseCase(
+	backup backup.Repository,
+	storage storage.Repository,
+) *InspectBackupUseCase {
+	return &InspectBackupUseCase{
+		backup:  backup,
+		storage: storage,
+	}
+}
+
+// Execute only downloads the head of the archive, the manifest is its first
+// entry. It returns backup.ErrNoManifest for archives without manifest.
+func (uc *InspectBackupUseCase) Execute(ctx context.Context, key string) (*entity.Manifest, error) {
+	r, err := uc.storage.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.backup.ReadManifest(ctx, r)
+}
+
+// newestFullBackup returns the newest full backup of the database accepted
+// by accept, leaving out the safety backups taken before a restore. It
+// returns nil when there is none. Candidates are picked from their metadata,
+// only the manifests of full backups are read.
+func newestFullBackup(
+	ctx context.Context,
+	s storage.Repository,
+	b backup.Repository,
+	policy *signature.Policy,
+	accept func(*entity.Backup, *entity.Manifest) bool,
+) (*entity.Backup, *entity.Manifest, error) {
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[string]bool, len(list))
+	candidates := make([]*entity.Backup, 0, len(list))
+	for _, o := range list {
+		names[o.Name] = true
+		if isSidecar(o.Name) || isBinlog(o.Name) || strings.HasPrefix(o.Name, "backup_") {
+			continue
+		}
+		describeBackup(o)
+		candidates = append(candidates, o)
+	}
+	// the time in the name survives copies, unlike the modification time
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return createdAt(candidates[i]).After(createdAt(candidates[j]))
+	})
+
+	for _, o := range candidates {
+		if o.Metadata == nil {
+			if stat, err := s.Stat(ctx, o.Path); err == nil {
+				o.Metadata = stat.Metadata
+				describeBackup(o)
+			}
+		}
+		if o.Metadata[metaKind] == entity.DifferentialBackup || o.Database != "" && o.Database != b.DatabaseName() {
+			continue
+		}
+
+		manifest, err := readManifest(ctx, s, b, o, names[o.Name+manifestExt], policy)
+		switch {
+		case errors.Is(err, backup.ErrNoManifest):
+			continue
+		case err != nil:
+			return nil, nil, fmt.Errorf("can't read the manifest of %s: %w", o.Name, err)
+		}
+		if !manifest.IsDifferential() && manifest.Database == b.DatabaseName() && accept(o, manifest) {
+			return o, manifest, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// createdAt returns when the backup was taken, or its modification time when
+// unknown.
+func createdAt(b *entity.Backup) time.Time {
+	if !b.CreatedAt.IsZero() {
+		return b.CreatedAt
+	}
+	return b.ModTime
+}
@@ -4,22 +4,86 @@ import (
 	"context"
 	"ez-snapshot/internal/entity"
 	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
 )
 
 type ListDatabaseUseCase struct {
 	storage storage.Repository
+	policy  *signature.Policy
 }
 
-func NewListDatabaseUseCase(storage storage.Repository) ListDatabaseUseCase {
+func NewListDatabaseUseCase(storage storage.Repository, policy *signature.Policy) ListDatabaseUseCase {
 	return ListDatabaseUseCase{
 		storage: storage,
+		policy:  policy,
 	}
 }
 
+// Execute lists the stored backups with their database, creation time,
+// checksum and tags. When trusted keys are configured the signature of every
+// backup is verified, and backups refused by the policy are left out.
 func (r ListDatabaseUseCase) Execute(ctx context.Context) ([]*entity.Backup, error) {
 	list, err := r.storage.List(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return list, nil
+
+	names := make(map[string]bool, len(list))
+	for _, b := range list {
+		names[b.Name] = true
+	}
+
+	backups := make([]*entity.Backup, 0, len(list))
+	for _, b := range list {
+		if isSidecar(b.Name) || isBinlog(b.Name) {
+			continue
+		}
+
+		if r.policy.Verifies() {
+			status := signature.Unsigned
+			if names[b.Name+checksumExt] && names[b.Name+signature.Ext] {
+				sidecar, err := readSidecar(ctx, r.storage, b.Path+checksumExt)
+				if err == nil {
+					status, err = verifySignature(ctx, r.storage, b.Path, sidecar, r.policy)
+				}
+				if err != nil {
+					fmt.Printf("⚠️ Can't verify the signature of %s: %v\n", b.Name, err)
+					status = signature.Unsigned
+				}
+			}
+			b.Signature = string(status)
+
+			if err := r.policy.Check(status); err != nil {
+				fmt.Printf("⚠️ Ignoring %s: %s\n", b.Name, status)
+				continue
+			}
+		}
+
+		r.describe(ctx, b, names[b.Name+checksumExt])
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// describe fills the parsed fields of b. Its metadata is read with Stat when
+// the listing doesn't return it, and its checksum from the sidecar when the
+// metadata doesn't hold it.
+func (r ListDatabaseUseCase) describe(ctx context.Context, b *entity.Backup, hasChecksum bool) {
+	if b.Metadata == nil {
+		stat, err := r.storage.Stat(ctx, b.Path)
+		if err != nil {
+			fmt.Printf("⚠️ Failed to read the metadata of %s: %v\n", b.Name, err)
+		} else {
+			b.Metadata = stat.Metadata
+			b.Size = stat.Size
+		}
+	}
+
+	describeBackup(b)
+	if b.Checksum == "" && hasChecksum {
+		if sidecar, err := readSidecar(ctx, r.storage, b.Path+checksumExt); err == nil {
+			b.Checksum, _ = parseChecksum(sidecar, b.Path)
+		}
+	}
 }
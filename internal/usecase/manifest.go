@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/backup"
+	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
+	"fmt"
+	"io"
+	"os"
+)
+
+// manifestExt is the extension of the sidecar object holding the manifest of
+// a full backup whose archive the backup host can't decrypt, eg : archives
+// encrypted to age recipients. Differential backups need it to find their
+// base.
+const manifestExt = ".manifest.json"
+
+// maxManifestSize bounds the manifest sidecar, it lists every table.
+const maxManifestSize = 16 << 20
+
+// uploadManifest uploads the manifest sidecar of the full backup in f, and
+// its signature when a signing key is configured, unless the archive can be
+// read back without it.
+func uploadManifest(ctx context.Context, s storage.Repository, b backup.Repository, name string, f *os.File, manifest *entity.Manifest, policy *signature.Policy) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := b.ReadManifest(ctx, io.NopCloser(f)); err == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := s.Upload(ctx, name+manifestExt, bytes.NewReader(data), nil); err != nil {
+		return fmt.Errorf("manifest upload failed: %w", err)
+	}
+	if policy.CanSign() {
+		if _, err := s.Upload(ctx, name+manifestExt+signature.Ext, bytes.NewReader(policy.Sign(data)), nil); err != nil {
+			return fmt.Errorf("manifest signature upload failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// readManifest reads the manifest of o from its sidecar when it has one, and
+// from the head of the archive otherwise.
+func readManifest(ctx context.Context, s storage.Repository, b backup.Repository, o *entity.Backup, hasSidecar bool, policy *signature.Policy) (*entity.Manifest, error) {
+	if !hasSidecar {
+		r, err := s.Download(ctx, o.Path)
+		if err != nil {
+			return nil, err
+		}
+		return b.ReadManifest(ctx, r)
+	}
+
+	r, err := s.Download(ctx, o.Path+manifestExt)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	if policy.Verifies() {
+		status, err := verifySignature(ctx, s, o.Path+manifestExt, data, policy)
+		if err != nil {
+			return nil, err
+		}
+		if err := policy.Check(status); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest entity.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest sidecar: %w", err)
+	}
+	return &manifest, nil
+}
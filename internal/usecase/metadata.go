@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"ez-snapshot/internal/entity"
+	"ez-snapshot/internal/repository/storage"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Keys of the metadata attached to the backups when they are uploaded.
+const (
+	metaDatabase  = "database"
+	metaEngine    = "engine"
+	metaCreatedAt = "created_at" // RFC 3339, UTC
+	metaKind      = "kind"       // entity.FullBackup or entity.DifferentialBackup
+	metaSHA256    = "sha256"
+	metaTagPrefix = "tag_" // followed by the tag key
+
+	// metaLastEvent is the time of the last event of an archived binary
+	// log, RFC 3339, UTC
+	metaLastEvent = "last_event"
+)
+
+var (
+	tagKeyPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+	// backupNamePattern matches the names given to the archives, eg :
+	// shop_20261019_101500.tar.zst, and to the safety backups taken before a
+	// restore, eg : backup_shop_20261019_101500.tar.zst.
+	backupNamePattern = regexp.MustCompile(`^(?:backup_)?(.+)_(\d{8}_\d{6})`)
+)
+
+// CheckTags rejects tags that can't be stored as metadata by every storage.
+func CheckTags(tags map[string]string) error {
+	for k, v := range tags {
+		if !tagKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid tag %q: keys are lower case letters, digits and underscores", k)
+		}
+		for _, c := range v {
+			if c < ' ' || c > '~' {
+				return fmt.Errorf("invalid tag %q: values are printable ASCII", k)
+			}
+		}
+	}
+	return nil
+}
+
+// archiveMetadata describes the archive of manifest.
+func archiveMetadata(manifest *entity.Manifest, tags map[string]string) storage.Metadata {
+	meta := storage.Metadata{
+		metaDatabase:  manifest.Database,
+		metaEngine:    manifest.Engine,
+		metaCreatedAt: manifest.CreatedAt.UTC().Format(time.RFC3339),
+		metaKind:      manifest.Kind,
+	}
+	for k, v := range tags {
+		meta[metaTagPrefix+k] = v
+	}
+
+	for k, v := range meta {
+		if v == "" {
+			delete(meta, k)
+		}
+	}
+	return meta
+}
+
+// parseBackupName returns the database and creation time held by the name
+// of a backup.
+func parseBackupName(name string) (string, time.Time, bool) {
+	m := backupNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", time.Time{}, false
+	}
+	createdAt, err := time.ParseInLocation("20060102_150405", m[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return m[1], createdAt, true
+}
+
+// describeBackup fills the parsed fields of b from its metadata, and from its
+// name for backups uploaded without metadata.
+func describeBackup(b *entity.Backup) {
+	meta := b.Metadata
+	b.Database = meta[metaDatabase]
+	b.Engine = meta[metaEngine]
+	if b.Checksum == "" {
+		b.Checksum = meta[metaSHA256]
+	}
+	if t, err := time.Parse(time.RFC3339, meta[metaCreatedAt]); err == nil {
+		b.CreatedAt = t
+	}
+
+	for k, v := range meta {
+		if tag, ok := strings.CutPrefix(k, metaTagPrefix); ok {
+			if b.Tags == nil {
+				b.Tags = map[string]string{}
+			}
+			b.Tags[tag] = v
+		}
+	}
+
+	if b.Database != "" && !b.CreatedAt.IsZero() {
+		return
+	}
+	if database, createdAt, ok := parseBackupName(b.Name); ok {
+		if b.Database == "" {
+			b.Database = database
+		}
+		if b.CreatedAt.IsZero() {
+			b.CreatedAt = createdAt
+		}
+	}
+}
@@ -2,39 +2,180 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"ez-snapshot/internal/entity"
 	"ez-snapshot/internal/repository/backup"
 	"ez-snapshot/internal/repository/storage"
+	"ez-snapshot/internal/signature"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RestoreDatabaseUseCase struct {
 	backup  backup.Repository
 	storage storage.Repository
+	policy  *signature.Policy
 }
 
 func NewRestoreDatabaseUseCase(
 	backup backup.Repository,
 	storage storage.Repository,
+	policy *signature.Policy,
 ) *RestoreDatabaseUseCase {
 	return &RestoreDatabaseUseCase{
 		backup:  backup,
 		storage: storage,
+		policy:  policy,
 	}
 }
 
 func (uc *RestoreDatabaseUseCase) Execute(ctx context.Context, key string) error {
 
+	// Step 1: Download the snapshot into a local staging file, nothing is
+	// touched until it has been verified
+	fmt.Println("Begin downloading snapshot file ...")
+	snapshot, err := downloadVerified(ctx, uc.storage, key, uc.policy)
+	if err != nil {
+		return fmt.Errorf("❌ can't download snapshot: %w", err)
+	}
+	defer removeFile(snapshot)
+
+	fmt.Println("✅Snapshot has been downloaded")
+
+	// Step 2: Verify the archive structure and the manifest checksums
+	fmt.Println("Verifying snapshot ...")
+	if err := uc.backup.Verify(ctx, snapshot); err != nil {
+		return fmt.Errorf("❌ snapshot verification failed: %w", err)
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Snapshot is valid")
+
+	// A differential snapshot is restored on top of its base full backup
+	manifest, err := uc.backup.ReadManifest(ctx, io.NopCloser(snapshot))
+	if err != nil && !errors.Is(err, backup.ErrNoManifest) {
+		return fmt.Errorf("❌ can't read snapshot manifest: %w", err)
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var base *os.File
+	var baseManifest *entity.Manifest
+	if manifest != nil && manifest.IsDifferential() {
+		fmt.Printf("Begin downloading base snapshot %s ...\n", manifest.Base)
+		base, baseManifest, err = uc.stageBase(ctx, manifest)
+		if err != nil {
+			return fmt.Errorf("❌ can't use base snapshot %s: %w", manifest.Base, err)
+		}
+		defer removeFile(base)
+
+		fmt.Println("✅ Base snapshot is valid")
+	}
+
+	if err := uc.clearDatabase(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("Begin restore process ...")
+	if base != nil {
+		if err := uc.backup.Restore(ctx, base); err != nil {
+			return fmt.Errorf("❌ base restore failed: %w", err)
+		}
+	}
+	if err := uc.backup.Restore(ctx, snapshot); err != nil {
+		return fmt.Errorf("❌ restore failed: %w", err)
+	}
+
+	// tables of the base dropped before the differential backup was taken
+	if baseManifest != nil {
+		var removed []string
+		for _, t := range baseManifest.Tables {
+			if _, ok := manifest.Table(t.Name); !ok {
+				removed = append(removed, t.Name)
+			}
+		}
+		if err := uc.backup.DropTables(ctx, removed); err != nil {
+			return fmt.Errorf("❌ drop removed tables failed: %w", err)
+		}
+	}
+	fmt.Println("✅ Restore has been complete")
+
+	return nil
+}
+
+// stageBase downloads and verifies the base full backup of a differential
+// backup, the caller must close and remove the file.
+func (uc *RestoreDatabaseUseCase) stageBase(ctx context.Context, diff *entity.Manifest) (*os.File, *entity.Manifest, error) {
+	list, err := uc.storage.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key string
+	for _, b := range list {
+		if b.Name == diff.Base {
+			key = b.Path
+		}
+	}
+	if key == "" {
+		return nil, nil, errors.New("base backup not found in storage")
+	}
+
+	f, err := downloadVerified(ctx, uc.storage, key, uc.policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest, err := uc.verifyBase(ctx, f, diff)
+	if err != nil {
+		removeFile(f)
+		return nil, nil, err
+	}
+	return f, manifest, nil
+}
+
+func (uc *RestoreDatabaseUseCase) verifyBase(ctx context.Context, f *os.File, diff *entity.Manifest) (*entity.Manifest, error) {
+	if err := uc.backup.Verify(ctx, f); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	manifest, err := uc.backup.ReadManifest(ctx, io.NopCloser(f))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if manifest.IsDifferential() || manifest.Database != diff.Database {
+		return nil, fmt.Errorf("not a full backup of %s", diff.Database)
+	}
+	return manifest, nil
+}
+
+// clearDatabase uploads a safety backup of the current database, then drops
+// every table.
+func (uc *RestoreDatabaseUseCase) clearDatabase(ctx context.Context) error {
 	fmt.Println("Backup existing database...")
-	// Step 1: Dump database
-	dumpPath, err := uc.backup.Dump(ctx)
+	// Step 3: Dump database
+	dumpPath, manifest, err := uc.backup.Dump(ctx)
 	if err != nil {
 		return fmt.Errorf("❌ dump failed: %w", err)
 	}
 	fmt.Println("✅ Backup created")
 
-	// Step 2: Rename file to "backup_xxxxx.tar.gz"
+	// Step 4: Rename file to "backup_xxxxx.tar.gz"
 	current, err := os.Stat(dumpPath)
 	if err != nil {
 		return err
@@ -49,43 +190,196 @@ func (uc *RestoreDatabaseUseCase) Execute(ctx context.Context, key string) error
 
 	fmt.Println("Upload to file storage ...")
 
-	// Step 3: Upload to storage
+	// Step 5: Upload to storage
 	f, err := os.Open(newPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	if _, err := uc.storage.Upload(ctx, filepath.Base(newPath), f); err != nil {
+	meta := archiveMetadata(manifest, nil)
+	if _, err := uploadWithChecksum(ctx, uc.storage, filepath.Base(newPath), f, meta, uc.policy); err != nil {
 		return fmt.Errorf("❌ backup upload failed: %w", err)
 	}
 
 	fmt.Println("✅Backup has been complete")
 
-	// Step 4: Drop all tables
+	fmt.Println("Dropping all tables ...")
+
+	// Step 6: Drop all tables
+	if err := uc.backup.DropAllTables(ctx); err != nil {
+		return fmt.Errorf("❌ drop all tables failed: %w", err)
+	}
+
+	fmt.Println("✅ Table has been dropped")
+
+	return nil
+}
+
+// ExecuteToPoint restores the nearest full backup taken before target, then
+// replays the archived binary logs up to target.
+func (uc *RestoreDatabaseUseCase) ExecuteToPoint(ctx context.Context, target backup.RecoveryTarget) error {
+	repo, ok := uc.backup.(backup.BinlogRepository)
+	if !ok {
+		return errors.New("point-in-time recovery is not supported by this database")
+	}
+
+	var targetGTIDs backup.GTIDSet
+	if target.GTIDSet != "" {
+		var err error
+		if targetGTIDs, err = backup.ParseGTIDSet(target.GTIDSet); err != nil {
+			return err
+		}
+	}
+
+	// Step 1: Find the nearest full backup and the binary logs following it
+	base, manifest, err := newestFullBackup(ctx, uc.storage, uc.backup, uc.policy, func(_ *entity.Backup, m *entity.Manifest) bool {
+		if m.Binlog == nil {
+			return false
+		}
+		if targetGTIDs != nil {
+			gtids, err := backup.ParseGTIDSet(m.Binlog.GTIDSet)
+			return err == nil && m.Binlog.GTIDSet != "" && targetGTIDs.Contains(gtids)
+		}
+		return !m.CreatedAt.After(target.Time)
+	})
+	if err != nil {
+		return err
+	}
+	if base == nil {
+		return fmt.Errorf("❌ no full backup with binary log coordinates taken before %s", target)
+	}
+
+	binlogs, err := uc.binlogsSince(ctx, manifest.Binlog.File, target)
+	if err != nil {
+		return fmt.Errorf("❌ %w", err)
+	}
+	fmt.Printf("Restoring %s and replaying %d binary log(s) up to %s\n", base.Name, len(binlogs), target)
+
+	// Step 2: Download and verify everything before touching the database
 	fmt.Println("Begin downloading snapshot file ...")
-	b, err := uc.storage.Download(ctx, key)
+	snapshot, err := downloadVerified(ctx, uc.storage, base.Path, uc.policy)
 	if err != nil {
 		return fmt.Errorf("❌ can't download snapshot: %w", err)
 	}
-	defer b.Close()
+	defer removeFile(snapshot)
 
-	fmt.Println("✅Snapshot has been downloaded")
+	if err := uc.backup.Verify(ctx, snapshot); err != nil {
+		return fmt.Errorf("❌ snapshot verification failed: %w", err)
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fmt.Println("✅ Snapshot is valid")
 
-	fmt.Println("Dropping all tables ...")
+	dir, err := os.MkdirTemp("", "ez-snapshot-binlog-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
 
-	// Step 5: Drop all tables
-	if err := uc.backup.DropAllTables(ctx); err != nil {
-		return fmt.Errorf("❌ drop all tables failed: %w", err)
+	files := make([]string, 0, len(binlogs))
+	for _, b := range binlogs {
+		fmt.Printf("Downloading %s ...\n", b.Name)
+		path, err := uc.stageBinlog(ctx, repo, b, dir)
+		if err != nil {
+			return fmt.Errorf("❌ can't download %s: %w", b.Name, err)
+		}
+		files = append(files, path)
 	}
+	fmt.Println("✅ Binary logs have been downloaded")
 
-	fmt.Println("✅ Table has been dropped")
+	// Step 3: Restore the snapshot and replay the binary logs
+	if err := uc.clearDatabase(ctx); err != nil {
+		return err
+	}
 
 	fmt.Println("Begin restore process ...")
-	if err := uc.backup.Restore(ctx, b); err != nil {
+	if err := uc.backup.Restore(ctx, snapshot); err != nil {
 		return fmt.Errorf("❌ restore failed: %w", err)
 	}
+
+	fmt.Println("Replaying binary logs ...")
+	if err := repo.ReplayBinlogs(ctx, files, manifest.Binlog, target); err != nil {
+		return fmt.Errorf("❌ %w", err)
+	}
 	fmt.Println("✅ Restore has been complete")
 
 	return nil
 }
+
+// binlogsSince returns the archived binary logs from first on. For a time
+// target the binary logs following the one target falls into are left out,
+// it is found from the time of their last event. Without it every following
+// binary log is kept, replay stops at target anyway.
+func (uc *RestoreDatabaseUseCase) binlogsSince(ctx context.Context, first string, target backup.RecoveryTarget) ([]*entity.Backup, error) {
+	archived, err := archivedBinlogs(ctx, uc.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var binlogs []*entity.Backup
+	for _, b := range archived {
+		name := strings.TrimPrefix(b.Name, binlogPrefix)
+		if len(binlogs) == 0 && name != first {
+			continue
+		}
+		if n := len(binlogs); n > 0 && !nextBinlog(strings.TrimPrefix(binlogs[n-1].Name, binlogPrefix), name) {
+			return nil, fmt.Errorf("binary logs are missing between %s and %s", binlogs[n-1].Name, b.Name)
+		}
+		binlogs = append(binlogs, b)
+
+		if target.GTIDSet != "" {
+			continue
+		}
+		if last, ok := uc.lastBinlogEvent(ctx, b); ok && !last.Before(target.Time) {
+			break
+		}
+	}
+
+	if len(binlogs) == 0 {
+		return nil, fmt.Errorf("binary log %s has not been archived", first)
+	}
+	return binlogs, nil
+}
+
+// lastBinlogEvent returns the time of the last event of an archived binary
+// log, from its metadata.
+func (uc *RestoreDatabaseUseCase) lastBinlogEvent(ctx context.Context, b *entity.Backup) (time.Time, bool) {
+	meta := b.Metadata
+	if meta == nil {
+		stat, err := uc.storage.Stat(ctx, b.Path)
+		if err != nil {
+			return time.Time{}, false
+		}
+		meta = stat.Metadata
+	}
+	last, err := time.Parse(time.RFC3339, meta[metaLastEvent])
+	return last, err == nil
+}
+
+// nextBinlog reports whether next directly follows prev, eg : binlog.000041
+// and binlog.000042.
+func nextBinlog(prev, next string) bool {
+	i, j := strings.LastIndex(prev, "."), strings.LastIndex(next, ".")
+	if i < 0 || j < 0 || prev[:i] != next[:j] {
+		return false
+	}
+	p, err1 := strconv.Atoi(prev[i+1:])
+	n, err2 := strconv.Atoi(next[j+1:])
+	return err1 == nil && err2 == nil && n == p+1
+}
+
+func (uc *RestoreDatabaseUseCase) stageBinlog(ctx context.Context, repo backup.BinlogRepository, b *entity.Backup, dir string) (string, error) {
+	f, err := downloadVerified(ctx, uc.storage, b.Path, uc.policy)
+	if err != nil {
+		return "", err
+	}
+	defer removeFile(f)
+
+	path := filepath.Join(dir, strings.TrimPrefix(b.Name, binlogPrefix))
+	if err := repo.UnpackBinlog(ctx, f, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,5 @@
+package version
+
+// Version of ez-snapshot, set at build time with
+// go build -ldflags "-X ez-snapshot/internal/version.Version=v1.0.0"
+var Version = "dev"